@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AlertGroup struct {
+	Message   string
+	Manager   string
+	Level     logrus.Level
+	Fields    logrus.Fields
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Count     int
+	Pending   int
+	SentAt    time.Time
+}
+
+type AlertHook struct {
+	AppName      string
+	Host         string
+	Port         int
+	From         string
+	To           string
+	Username     string
+	Password     string
+	KeyInterval  time.Duration
+	Digest       time.Duration
+	ResolveAfter time.Duration
+	Threshold    int
+	mu           sync.Mutex
+	groups       map[string]*AlertGroup
+}
+
+func newAlertHook() *AlertHook {
+	return &AlertHook{
+		AppName:      config.SMTP.AppName,
+		Host:         config.SMTP.Host,
+		Port:         config.SMTP.Port,
+		From:         config.SMTP.From,
+		To:           config.SMTP.To,
+		Username:     config.SMTP.Username,
+		Password:     config.SMTP.Password,
+		KeyInterval:  config.Alert.KeyInterval,
+		Digest:       config.Alert.Digest,
+		ResolveAfter: config.Alert.ResolveAfter,
+		Threshold:    config.Alert.Threshold,
+		groups:       make(map[string]*AlertGroup),
+	}
+}
+
+func (hook *AlertHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (hook *AlertHook) Fire(entry *logrus.Entry) error {
+	manager := fmt.Sprint(entry.Data["manager"])
+
+	if _, ok := entry.Data["manager"]; !ok {
+		manager = ""
+	}
+
+	key := entry.Message + "|" + manager
+	now := time.Now()
+
+	hook.mu.Lock()
+	group, ok := hook.groups[key]
+
+	if !ok {
+		group = &AlertGroup{Message: entry.Message, Manager: manager, FirstSeen: now}
+		hook.groups[key] = group
+	}
+
+	group.Level = entry.Level
+	group.Fields = entry.Data
+	group.LastSeen = now
+	group.Count = group.Count + 1
+	group.Pending = group.Pending + 1
+
+	send := entry.Level <= logrus.FatalLevel || now.Sub(group.SentAt) >= hook.KeyInterval
+
+	var body string
+
+	if send {
+		group.SentAt = now
+		group.Pending = 0
+		body = hook.format(group)
+	}
+	hook.mu.Unlock()
+
+	if !send {
+		return nil
+	}
+
+	subject := fmt.Sprintf("%s - %s", hook.AppName, entry.Message)
+
+	if entry.Level <= logrus.FatalLevel {
+		return hook.send(subject, body)
+	}
+
+	go hook.send(subject, body)
+
+	return nil
+}
+
+func (hook *AlertHook) format(group *AlertGroup) string {
+	var body bytes.Buffer
+
+	fmt.Fprintf(&body, "Level: %s\n", group.Level)
+	fmt.Fprintf(&body, "Message: %s\n", group.Message)
+
+	if group.Manager != "" {
+		fmt.Fprintf(&body, "Manager: %s\n", group.Manager)
+	}
+
+	fmt.Fprintf(&body, "Count: %d\n", group.Count)
+	fmt.Fprintf(&body, "First seen: %s\n", group.FirstSeen.Format(time.RFC3339))
+	fmt.Fprintf(&body, "Last seen: %s\n", group.LastSeen.Format(time.RFC3339))
+
+	keys := make([]string, 0, len(group.Fields))
+
+	for key := range group.Fields {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(&body, "%s: %v\n", key, group.Fields[key])
+	}
+
+	return body.String()
+}
+
+func (hook *AlertHook) send(subject string, body string) error {
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", hook.From, hook.To, subject, body)
+	auth := smtp.PlainAuth("", hook.Username, hook.Password, hook.Host)
+
+	err := smtp.SendMail(fmt.Sprintf("%s:%d", hook.Host, hook.Port), auth, hook.From, strings.Split(hook.To, ","), []byte(message))
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can`t send alert email: %s\n", err)
+	}
+
+	return err
+}
+
+func (hook *AlertHook) flush(now time.Time) {
+	var digest bytes.Buffer
+	var resolved []*AlertGroup
+
+	hook.mu.Lock()
+	for key, group := range hook.groups {
+		if now.Sub(group.LastSeen) >= hook.ResolveAfter {
+			if group.Count >= hook.Threshold {
+				resolved = append(resolved, group)
+			}
+
+			delete(hook.groups, key)
+
+			continue
+		}
+
+		if group.Pending > 0 {
+			fmt.Fprintf(&digest, "%d new (%d total) x %s", group.Pending, group.Count, group.Message)
+
+			if group.Manager != "" {
+				fmt.Fprintf(&digest, " [manager %s]", group.Manager)
+			}
+
+			fmt.Fprintf(&digest, ", last at %s\n", group.LastSeen.Format(time.RFC3339))
+
+			group.Pending = 0
+		}
+	}
+	hook.mu.Unlock()
+
+	if digest.Len() > 0 {
+		hook.send(fmt.Sprintf("%s - error digest", hook.AppName), digest.String())
+	}
+
+	for _, group := range resolved {
+		hook.send(fmt.Sprintf("%s - resolved: %s", hook.AppName, group.Message), hook.format(group))
+	}
+}
+
+func (hook *AlertHook) run() {
+	ticker := time.NewTicker(hook.Digest)
+	defer ticker.Stop()
+
+	for t := range ticker.C {
+		hook.flush(t)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
@@ -21,6 +22,18 @@ type SuccessLoginResponse struct {
 	Ok          bool   `json:"ok"`
 }
 
+type ChatHistoryResponse struct {
+	Messages []map[string]interface{} `json:"messages"`
+	Ok       bool                     `json:"ok"`
+}
+
+type ActiveChatsResponse struct {
+	Chats []map[string]interface{} `json:"chats"`
+	Ok    bool                     `json:"ok"`
+}
+
+var errUnauthorized = errors.New("access token rejected")
+
 type UploadImageEndpoint struct {
 	URL        string `json:"url"`
 	Date       string `json:"date"`
@@ -32,7 +45,7 @@ type UploadImageEndpoint struct {
 	Ok         bool   `json:"ok"`
 }
 
-func uploadImageToEndpoint(agentImageCommand AgentImageCommand, uploadImageEndpoint *UploadImageEndpoint, data []byte) (*string, error) {
+func uploadImageToEndpoint(attachment *Attachment, uploadImageEndpoint *UploadImageEndpoint) (*string, error) {
 	var err error
 
 	bodyBuf := &bytes.Buffer{}
@@ -42,13 +55,13 @@ func uploadImageToEndpoint(agentImageCommand AgentImageCommand, uploadImageEndpo
 	acl.Write([]byte("public-read"))
 
 	ct, err := bodyWriter.CreateFormField("Content-Type")
-	ct.Write([]byte(agentImageCommand.Params.Image.Type))
+	ct.Write([]byte(attachment.Type))
 
 	key, err := bodyWriter.CreateFormField("key")
 	key.Write([]byte(uploadImageEndpoint.Key))
 
 	cd, err := bodyWriter.CreateFormField("Content-disposition")
-	cd.Write([]byte(fmt.Sprintf("attachment; filename*=UTF-8''%s", agentImageCommand.Params.Image.Name)))
+	cd.Write([]byte(fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(attachment.Name))))
 
 	xad, err := bodyWriter.CreateFormField("X-Amz-Date")
 	xad.Write([]byte(uploadImageEndpoint.Date))
@@ -65,13 +78,13 @@ func uploadImageToEndpoint(agentImageCommand AgentImageCommand, uploadImageEndpo
 	xas, err := bodyWriter.CreateFormField("X-Amz-Signature")
 	xas.Write([]byte(uploadImageEndpoint.Signature))
 
-	writer, err := bodyWriter.CreateFormFile("file", agentImageCommand.Params.Image.Name)
+	writer, err := bodyWriter.CreateFormFile("file", attachment.Name)
 
 	if err != nil {
 		return nil, err
 	}
 
-	imageData := bytes.NewReader(data)
+	imageData := bytes.NewReader(attachment.Data)
 
 	_, err = io.Copy(writer, imageData)
 
@@ -92,12 +105,13 @@ func uploadImageToEndpoint(agentImageCommand AgentImageCommand, uploadImageEndpo
 	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
 	req.Header.Set("Connection", "keep-alive")
 	req.Header.Set("Host", "files.jivosite.com")
-	req.Header.Set("Origin", "https://app.jivosite.com")
-	req.Header.Set("Referer", "https://app.jivosite.com")
+	req.Header.Set("Origin", config.JivoSite.AppURL)
+	req.Header.Set("Referer", config.JivoSite.AppURL)
 	req.Header.Set("Content-Type", bodyWriter.FormDataContentType())
 	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/69.0.3497.100 Safari/537.36")
 
-	resp, err := http.DefaultClient.Do(req)
+	client := &http.Client{Timeout: config.Timeouts.HTTP}
+	resp, err := client.Do(req)
 
 	if err != nil {
 		return nil, err
@@ -113,8 +127,11 @@ func uploadImageToEndpoint(agentImageCommand AgentImageCommand, uploadImageEndpo
 func getUploadImageEndpoint(manager *Manager, ext string) (*UploadImageEndpoint, error) {
 	var err error
 
-	endpointApiUrl := fmt.Sprintf("https://api.jivosite.com/api/1.0/sites/%d/rmo/media/transfer/access/gain?extension=%s&allow_content_type=%d", 839750, ext, 1)
-	fmt.Println(endpointApiUrl)
+	endpointApiUrl := fmt.Sprintf("%s/api/1.0/sites/%d/rmo/media/transfer/access/gain?extension=%s&allow_content_type=%d", config.JivoSite.ApiURL, config.JivoSite.SiteID, ext, 1)
+	logger.WithFields(logrus.Fields{
+		"manager": manager.Id,
+		"url":     endpointApiUrl,
+	}).Debug("Request upload endpoint:")
 
 	data := url.Values{}
 
@@ -126,10 +143,10 @@ func getUploadImageEndpoint(manager *Manager, ext string) (*UploadImageEndpoint,
 
 	req.Header.Set("Authorization", manager.SuccessLoginResponse.AccessToken)
 	req.Header.Set("Host", "api.jivosite.com")
-	req.Header.Set("Origin", "https://app.jivosite.com")
-	req.Header.Set("Referer", "https://app.jivosite.com")
+	req.Header.Set("Origin", config.JivoSite.AppURL)
+	req.Header.Set("Referer", config.JivoSite.AppURL)
 
-	client := &http.Client{}
+	client := &http.Client{Timeout: config.Timeouts.HTTP}
 	resp, err := client.Do(req)
 
 	if err != nil {
@@ -155,10 +172,118 @@ func getUploadImageEndpoint(manager *Manager, ext string) (*UploadImageEndpoint,
 	return uploadImageEndpoint, nil
 }
 
+func getChatHistory(manager *Manager, chatId int, limit int) (*ChatHistoryResponse, error) {
+	var err error
+
+	historyApiUrl := fmt.Sprintf("%s/api/1.0/sites/%d/rmo/chats/%d/messages?limit=%d", config.JivoSite.ApiURL, config.JivoSite.SiteID, chatId, limit)
+	manager.log().WithFields(logrus.Fields{
+		"url": historyApiUrl,
+	}).Debug("Request chat history:")
+
+	req, err := http.NewRequest("GET", historyApiUrl, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", manager.SuccessLoginResponse.AccessToken)
+	req.Header.Set("Host", "api.jivosite.com")
+	req.Header.Set("Origin", config.JivoSite.AppURL)
+	req.Header.Set("Referer", config.JivoSite.AppURL)
+
+	client := &http.Client{Timeout: config.Timeouts.HTTP}
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, errUnauthorized
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("chat history request failed: %s", resp.Status)
+	}
+
+	responseBody, _ := ioutil.ReadAll(resp.Body)
+
+	chatHistoryResponse := &ChatHistoryResponse{}
+	err = json.Unmarshal(responseBody, chatHistoryResponse)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if chatHistoryResponse.Ok == false {
+		err := errors.New("chat history request failed")
+		return nil, err
+	}
+
+	return chatHistoryResponse, nil
+}
+
+func getActiveChats(manager *Manager) (*ActiveChatsResponse, error) {
+	var err error
+
+	chatsApiUrl := fmt.Sprintf("%s/api/1.0/sites/%d/rmo/chats?state=active", config.JivoSite.ApiURL, config.JivoSite.SiteID)
+	manager.log().WithFields(logrus.Fields{
+		"url": chatsApiUrl,
+	}).Debug("Request active chats:")
+
+	req, err := http.NewRequest("GET", chatsApiUrl, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", manager.SuccessLoginResponse.AccessToken)
+	req.Header.Set("Host", "api.jivosite.com")
+	req.Header.Set("Origin", config.JivoSite.AppURL)
+	req.Header.Set("Referer", config.JivoSite.AppURL)
+
+	client := &http.Client{Timeout: config.Timeouts.HTTP}
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, errUnauthorized
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("active chats request failed: %s", resp.Status)
+	}
+
+	responseBody, _ := ioutil.ReadAll(resp.Body)
+
+	activeChatsResponse := &ActiveChatsResponse{}
+	err = json.Unmarshal(responseBody, activeChatsResponse)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if activeChatsResponse.Ok == false {
+		err := errors.New("active chats request failed")
+		return nil, err
+	}
+
+	return activeChatsResponse, nil
+}
+
 func getApiKey(login *string, pass *string) (*SuccessLoginResponse, error) {
 	var err error
-	loginApiUrl := "https://api.jivosite.com/api/1.0/auth/agent/access"
-	fmt.Println("URL:>", loginApiUrl)
+	loginApiUrl := config.JivoSite.ApiURL + "/api/1.0/auth/agent/access"
+	logger.WithFields(logrus.Fields{
+		"url": loginApiUrl,
+	}).Debug("Request login:")
 
 	data := url.Values{}
 
@@ -172,11 +297,11 @@ func getApiKey(login *string, pass *string) (*SuccessLoginResponse, error) {
 	}
 
 	req.Header.Set("Host", "api.jivosite.com")
-	req.Header.Set("Origin", "https://app.jivosite.com")
-	req.Header.Set("Referer", "https://app.jivosite.com")
+	req.Header.Set("Origin", config.JivoSite.AppURL)
+	req.Header.Set("Referer", config.JivoSite.AppURL)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
 
-	client := &http.Client{}
+	client := &http.Client{Timeout: config.Timeouts.HTTP}
 	resp, err := client.Do(req)
 
 	if err != nil {
@@ -206,8 +331,10 @@ func getApiKey(login *string, pass *string) (*SuccessLoginResponse, error) {
 func refreshApiKey(manager *Manager) (*SuccessLoginResponse, error) {
 	var err error
 
-	refreshApiUrl := "https://api.jivosite.com/api/1.0/auth/access/refresh"
-	fmt.Println("URL:>", refreshApiUrl)
+	refreshApiUrl := config.JivoSite.ApiURL + "/api/1.0/auth/access/refresh"
+	manager.log().WithFields(logrus.Fields{
+		"url": refreshApiUrl,
+	}).Debug("Request token refresh:")
 
 	data := url.Values{}
 	data.Set("token", manager.SuccessLoginResponse.AccessToken)
@@ -219,11 +346,11 @@ func refreshApiKey(manager *Manager) (*SuccessLoginResponse, error) {
 	}
 
 	req.Header.Set("Host", "api.jivosite.com")
-	req.Header.Set("Origin", "https://app.jivosite.com")
-	req.Header.Set("Referer", "https://app.jivosite.com")
+	req.Header.Set("Origin", config.JivoSite.AppURL)
+	req.Header.Set("Referer", config.JivoSite.AppURL)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
 
-	client := &http.Client{}
+	client := &http.Client{Timeout: config.Timeouts.HTTP}
 	resp, err := client.Do(req)
 
 	if err != nil {
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+type AttachmentPolicy struct {
+	MaxFileSize  int
+	MaxPixels    int
+	AllowedTypes []string
+}
+
+type Attachment struct {
+	Name   string
+	Type   string
+	Data   []byte
+	Width  int
+	Height int
+	Photo  bool
+}
+
+var attachmentPolicy *AttachmentPolicy
+
+func loadAttachmentPolicy() *AttachmentPolicy {
+	policy := &AttachmentPolicy{
+		MaxFileSize:  config.Attachment.MaxFileSize,
+		MaxPixels:    config.Attachment.MaxPixels,
+		AllowedTypes: []string{},
+	}
+
+	for _, t := range config.Attachment.AllowedTypes {
+		policy.AllowedTypes = append(policy.AllowedTypes, strings.ToLower(t))
+	}
+
+	return policy
+}
+
+func (policy *AttachmentPolicy) allowed(mimeType string) bool {
+	for _, t := range policy.AllowedTypes {
+		if t == strings.ToLower(mimeType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isPhoto(mimeType string) bool {
+	return mimeType == "image/jpeg" || mimeType == "image/png"
+}
+
+func mediaType(value string) string {
+	if i := strings.Index(value, ";"); i >= 0 {
+		value = value[:i]
+	}
+
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+func contentMatches(declared string, data []byte) bool {
+	declared = mediaType(declared)
+	detected := mediaType(http.DetectContentType(data))
+
+	switch {
+	case declared == detected:
+		return true
+	case detected == "application/zip":
+		return strings.Contains(declared, "openxmlformats") || strings.Contains(declared, "opendocument") || strings.HasSuffix(declared, "+zip")
+	case detected == "text/plain":
+		return strings.HasPrefix(declared, "text/")
+	}
+
+	return false
+}
+
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.Replace(name, "\\", "/", -1))
+
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune("\"'/\\;:*?<>|%", r) {
+			return '_'
+		}
+
+		return r
+	}, name)
+
+	name = strings.Trim(name, ". ")
+
+	if name == "" {
+		name = "file"
+	}
+
+	if len(name) > 200 {
+		ext := filepath.Ext(name)
+		if len(ext) > 20 {
+			ext = ""
+		}
+		base := name[:len(name)-len(ext)]
+		cut := 200 - len(ext)
+		for cut > 0 && !utf8.RuneStart(base[cut]) {
+			cut--
+		}
+		name = base[:cut] + ext
+	}
+
+	return name
+}
+
+func (policy *AttachmentPolicy) validate(name string, mimeType string, data []byte) (*Attachment, error) {
+	if len(data) == 0 {
+		return nil, errors.New("attachment is empty")
+	}
+
+	if len(data) > policy.MaxFileSize {
+		return nil, fmt.Errorf("attachment size %d exceeds limit %d", len(data), policy.MaxFileSize)
+	}
+
+	if !policy.allowed(mimeType) {
+		return nil, fmt.Errorf("attachment type %q is not allowed", mimeType)
+	}
+
+	if !contentMatches(mimeType, data) {
+		return nil, fmt.Errorf("attachment content %q does not match type %q", http.DetectContentType(data), mimeType)
+	}
+
+	attachment := &Attachment{
+		Name:  sanitizeFileName(name),
+		Type:  mimeType,
+		Data:  data,
+		Photo: isPhoto(mimeType),
+	}
+
+	if attachment.Photo {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+		if err != nil {
+			return nil, fmt.Errorf("can`t decode image: %s", err)
+		}
+
+		if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > policy.MaxPixels {
+			return nil, fmt.Errorf("image %dx%d exceeds pixel limit %d", config.Width, config.Height, policy.MaxPixels)
+		}
+
+		attachment.Width = config.Width
+		attachment.Height = config.Height
+	}
+
+	return attachment, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAttachmentValidate(t *testing.T) {
+	picture := bytes.Buffer{}
+
+	err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &AttachmentPolicy{MaxFileSize: 1024, MaxPixels: 100, AllowedTypes: []string{"image/png", "image/jpeg", "application/pdf"}}
+	pdf := []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<<>>\nendobj\n")
+
+	tests := []struct {
+		name     string
+		mimeType string
+		data     []byte
+		valid    bool
+	}{
+		{"png", "image/png", picture.Bytes(), true},
+		{"pdf", "application/pdf", pdf, true},
+		{"parameters are not in the allowlist", "Application/PDF; charset=binary", pdf, false},
+		{"empty", "application/pdf", nil, false},
+		{"too large", "application/pdf", append(pdf, make([]byte, 1024)...), false},
+		{"type not allowed", "text/html", []byte("<html></html>"), false},
+		{"executable declared as pdf", "application/pdf", []byte("MZ\x90\x00\x03\x00\x00\x00"), false},
+		{"html declared as pdf", "application/pdf", []byte("<html><script></script></html>"), false},
+		{"png declared as jpeg", "image/jpeg", picture.Bytes(), false},
+		{"pdf declared as png", "image/png", pdf, false},
+	}
+
+	for _, test := range tests {
+		attachment, err := policy.validate("file", test.mimeType, test.data)
+
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %v", test.name, err, test.valid)
+		}
+
+		if attachment != nil && attachment.Photo && (attachment.Width != 4 || attachment.Height != 3) {
+			t.Errorf("%s: got %dx%d", test.name, attachment.Width, attachment.Height)
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{"C:\\Users\\anna\\photo.png", "photo.png"},
+		{"a;b|c?.png", "a_b_c_.png"},
+		{" ..", "file"},
+		{strings.Repeat("a", 250) + ".pdf", strings.Repeat("a", 196) + ".pdf"},
+		{strings.Repeat("я", 150) + ".pdf", strings.Repeat("я", 98) + ".pdf"},
+	}
+
+	for _, test := range tests {
+		got := sanitizeFileName(test.in)
+
+		if got != test.want || !utf8.ValidString(got) || len(got) > 200 {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
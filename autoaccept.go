@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const autoAcceptPendingTTL = time.Minute
+
+type AutoAcceptPolicy struct {
+	Enabled  bool `json:"enabled"`
+	MaxChats int  `json:"max_chats"`
+	Schedule
+}
+
+type AutoAcceptCommand struct {
+	ManagerID string `json:"managerId"`
+	Params    struct {
+		Name string `json:"name"`
+		AutoAcceptPolicy
+	} `json:"params"`
+}
+
+type AutoAcceptEventParams struct {
+	Name      string           `json:"name"`
+	ManagerID string           `json:"manager_id"`
+	Policy    AutoAcceptPolicy `json:"policy"`
+}
+
+type AutoAcceptedEventParams struct {
+	Name      string `json:"name"`
+	ManagerID string `json:"manager_id"`
+	ChatID    int    `json:"chat_id"`
+	ClientID  int    `json:"client_id"`
+	OpenChats int    `json:"open_chats"`
+}
+
+func defaultAutoAcceptPolicy() AutoAcceptPolicy {
+	return AutoAcceptPolicy{
+		Enabled:  config.AutoAccept.Enabled,
+		MaxChats: config.AutoAccept.MaxChats,
+		Schedule: Schedule{
+			Hours: config.AutoAccept.Hours,
+			Days:  config.AutoAccept.Days,
+		},
+	}
+}
+
+func (policy AutoAcceptPolicy) validate() error {
+	if policy.MaxChats < 0 {
+		return fmt.Errorf("max_chats must not be negative")
+	}
+
+	return policy.Schedule.validate()
+}
+
+func autoAcceptEvent(event string) bool {
+	for _, name := range config.AutoAccept.Events {
+		if name == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (manager *Manager) autoAcceptPolicy() AutoAcceptPolicy {
+	if policy, ok := manager.autoAccept.Load().(AutoAcceptPolicy); ok {
+		return policy
+	}
+
+	return defaultAutoAcceptPolicy()
+}
+
+func (manager *Manager) loadAutoAccept() {
+	if MySQL == nil {
+		return
+	}
+
+	policy, err := getAutoAcceptPolicy(manager.Id)
+
+	if err == nil && policy != nil {
+		err = policy.validate()
+	}
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Manager can`t load auto accept policy:")
+
+		return
+	}
+
+	if policy != nil {
+		manager.autoAccept.Store(*policy)
+	}
+}
+
+func (manager *Manager) autoAcceptCommand(command []byte) error {
+	autoAcceptCommand := AutoAcceptCommand{}
+
+	err := json.Unmarshal(command, &autoAcceptCommand)
+
+	if err != nil {
+		return err
+	}
+
+	policy := autoAcceptCommand.Params.AutoAcceptPolicy
+
+	err = policy.validate()
+
+	if err != nil {
+		return err
+	}
+
+	manager.autoAccept.Store(policy)
+
+	if MySQL != nil {
+		err = setAutoAcceptPolicy(manager.Id, policy)
+
+		if err != nil {
+			manager.log().WithFields(logrus.Fields{
+				"err": err,
+			}).Error("Manager can`t store auto accept policy:")
+		}
+	}
+
+	return publishServiceEvent(AutoAcceptEventParams{
+		Name:      "auto_accept",
+		ManagerID: manager.Id,
+		Policy:    policy,
+	})
+}
+
+func (manager *Manager) openChats(at time.Time) int {
+	open := 0
+
+	if manager.chats != nil {
+		open = len(manager.chats.list(false))
+	}
+
+	for chatId, acceptedAt := range manager.accepting {
+		_, tracked := manager.chats.get(chatId)
+
+		if tracked || at.Sub(acceptedAt) > autoAcceptPendingTTL {
+			delete(manager.accepting, chatId)
+			continue
+		}
+
+		open = open + 1
+	}
+
+	return open
+}
+
+func (manager *Manager) autoAcceptOffer(event string, params map[string]interface{}, at time.Time) {
+	if params == nil || manager.chats == nil || !autoAcceptEvent(event) {
+		return
+	}
+
+	chatId := intParam(params, "chat_id")
+	clientId := intParam(params, "client_id")
+	policy := manager.autoAcceptPolicy()
+
+	if chatId <= 0 || !policy.Enabled {
+		return
+	}
+
+	if manager.accepting == nil {
+		manager.accepting = make(map[int]time.Time)
+	}
+
+	if _, ok := manager.accepting[chatId]; ok {
+		return
+	}
+
+	if chat, ok := manager.chats.get(chatId); ok && chat.State == ChatOpen {
+		return
+	}
+
+	open := manager.openChats(at)
+	reason := ""
+
+	switch {
+	case manager.away():
+		reason = "manager is away"
+	case !policy.within(at, config.AutoAccept.Timezone):
+		reason = "outside business hours"
+	case policy.MaxChats > 0 && open >= policy.MaxChats:
+		reason = "chat limit reached"
+	}
+
+	if reason != "" {
+		manager.log().WithFields(logrus.Fields{
+			"chat_id": chatId,
+			"open":    open,
+			"reason":  reason,
+		}).Info("Chat offer not auto accepted:")
+
+		return
+	}
+
+	request := AcceptCommand{ID: manager.nextRequestId(), Method: "cometan", Jsonrpc: "2.0"}
+	request.Params.Name = "accept"
+	request.Params.ChatID = chatId
+	request.Params.ClientID = clientId
+
+	manager.trackPending(request.ID, PendingRequest{
+		Command: request.Params.Name,
+		ChatID:  chatId,
+		Accepted: &AutoAcceptedEventParams{
+			Name:      "auto_accepted",
+			ManagerID: manager.Id,
+			ChatID:    chatId,
+			ClientID:  clientId,
+			OpenChats: open + 1,
+		},
+	})
+
+	manager.mu.Lock()
+	err := manager.sendJSON(request)
+	manager.mu.Unlock()
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"chat_id": chatId,
+			"err":     err,
+		}).Error("Manager can`t auto accept chat:")
+
+		return
+	}
+
+	manager.accepting[chatId] = at
+}
+
+func (manager *Manager) autoAccepted(accepted AutoAcceptedEventParams, ok bool) {
+	if !ok {
+		delete(manager.accepting, accepted.ChatID)
+
+		manager.log().WithFields(logrus.Fields{
+			"chat_id": accepted.ChatID,
+		}).Warn("Chat auto accept rejected:")
+
+		return
+	}
+
+	countMetric("auto_accepted", 1)
+
+	manager.log().WithFields(logrus.Fields{
+		"chat_id": accepted.ChatID,
+		"open":    accepted.OpenChats,
+	}).Info("Chat auto accepted:")
+
+	err := publishServiceEvent(accepted)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"chat_id": accepted.ChatID,
+			"error":   err,
+		}).Error("Failed to publish:")
+	}
+}
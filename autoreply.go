@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type AutoReplyRule struct {
+	ID           int
+	SiteID       int
+	ManagerID    string
+	Priority     int
+	WhenAway     bool
+	FirstMessage bool
+	Keywords     []string
+	Schedule
+	Template string
+	Cooldown time.Duration
+	template *template.Template
+}
+
+type AutoReplyData struct {
+	ManagerID  string
+	ChatID     int
+	ClientID   int
+	ClientName string
+	Message    string
+	Time       time.Time
+}
+
+type AutoRepliedEventParams struct {
+	Name      string `json:"name"`
+	ManagerID string `json:"manager_id"`
+	RuleID    int    `json:"rule_id"`
+	ChatID    int    `json:"chat_id"`
+	ClientID  int    `json:"client_id"`
+	PrivateID string `json:"private_id"`
+	Message   string `json:"message"`
+}
+
+type AutoReplyEngine struct {
+	rules     []AutoReplyRule
+	cooldowns map[string]time.Time
+	mu        sync.Mutex
+}
+
+var autoReplies = &AutoReplyEngine{cooldowns: make(map[string]time.Time)}
+
+func (engine *AutoReplyEngine) load() error {
+	rules, err := getAutoReplyRules()
+
+	if err != nil {
+		return err
+	}
+
+	valid := []AutoReplyRule{}
+
+	for _, rule := range rules {
+		err = rule.Schedule.validate()
+
+		if err == nil {
+			rule.template, err = template.New(fmt.Sprintf("rule%d", rule.ID)).Parse(rule.Template)
+		}
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"rule": rule.ID,
+				"err":  err,
+			}).Error("Auto reply rule is invalid:")
+
+			continue
+		}
+
+		valid = append(valid, rule)
+	}
+
+	engine.mu.Lock()
+	engine.rules = valid
+	engine.mu.Unlock()
+
+	logger.WithFields(logrus.Fields{
+		"rules": len(valid),
+	}).Debug("Auto reply rules loaded:")
+
+	return nil
+}
+
+func (engine *AutoReplyEngine) refresh() {
+	for {
+		err := engine.load()
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"err": err,
+			}).Error("Can`t load auto reply rules:")
+		}
+
+		time.Sleep(config.AutoReply.Refresh)
+	}
+}
+
+func (rule AutoReplyRule) matches(manager *Manager, siteId int, text string, first bool, at time.Time) bool {
+	if rule.SiteID != 0 && rule.SiteID != siteId {
+		return false
+	}
+
+	if rule.WhenAway && !manager.away() {
+		return false
+	}
+
+	if rule.FirstMessage && !first {
+		return false
+	}
+
+	if !rule.within(at, config.AutoReply.Timezone) {
+		return false
+	}
+
+	if len(rule.Keywords) == 0 {
+		return true
+	}
+
+	text = strings.ToLower(text)
+
+	for _, keyword := range rule.Keywords {
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (engine *AutoReplyEngine) match(manager *Manager, siteId int, chatId int, text string, first bool, at time.Time) *AutoReplyRule {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	for key, until := range engine.cooldowns {
+		if at.After(until) {
+			delete(engine.cooldowns, key)
+		}
+	}
+
+	for i := range engine.rules {
+		rule := engine.rules[i]
+		key := fmt.Sprintf("%d:%d", rule.ID, chatId)
+
+		if _, ok := engine.cooldowns[key]; ok {
+			continue
+		}
+
+		if !rule.matches(manager, siteId, text, first, at) {
+			continue
+		}
+
+		cooldown := rule.Cooldown
+
+		if cooldown <= 0 {
+			cooldown = config.AutoReply.Cooldown
+		}
+
+		engine.cooldowns[key] = at.Add(cooldown)
+
+		return &rule
+	}
+
+	return nil
+}
+
+func (engine *AutoReplyEngine) release(ruleId int, chatId int) {
+	engine.mu.Lock()
+	delete(engine.cooldowns, fmt.Sprintf("%d:%d", ruleId, chatId))
+	engine.mu.Unlock()
+}
+
+func (manager *Manager) autoReply(server *Server, event string, params map[string]interface{}, at time.Time) {
+	if !config.AutoReply.Enabled || event != "client_message" || params == nil {
+		return
+	}
+
+	chatId := intParam(params, "chat_id")
+
+	if chatId <= 0 {
+		return
+	}
+
+	first := false
+
+	if manager.chats != nil {
+		chat, ok := manager.chats.get(chatId)
+		first = !ok || chat.LastMessageAt == nil
+	}
+
+	siteId := intParam(params, "site_id")
+
+	if siteId == 0 {
+		siteId = config.JivoSite.SiteID
+	}
+
+	text := stringParam(params, "message", "text")
+	rule := autoReplies.match(manager, siteId, chatId, text, first, at)
+
+	if rule == nil {
+		return
+	}
+
+	sender := manager
+
+	if rule.ManagerID != "" && rule.ManagerID != manager.Id {
+		other, ok := server.manager(rule.ManagerID)
+
+		if !ok {
+			manager.log().WithFields(logrus.Fields{
+				"rule":    rule.ID,
+				"chat_id": chatId,
+				"sender":  rule.ManagerID,
+			}).Warn("Auto reply manager is offline:")
+
+			autoReplies.release(rule.ID, chatId)
+
+			return
+		}
+
+		sender = other
+	}
+
+	data := AutoReplyData{
+		ManagerID:  sender.Id,
+		ChatID:     chatId,
+		ClientID:   intParam(params, "client_id"),
+		ClientName: stringParam(params, "client_name"),
+		Message:    text,
+		Time:       at,
+	}
+
+	reply := bytes.Buffer{}
+
+	err := rule.template.Execute(&reply, data)
+
+	if err != nil || strings.TrimSpace(reply.String()) == "" {
+		manager.log().WithFields(logrus.Fields{
+			"rule":    rule.ID,
+			"chat_id": chatId,
+			"err":     err,
+		}).Error("Can`t render auto reply:")
+
+		autoReplies.release(rule.ID, chatId)
+
+		return
+	}
+
+	commandToSend := AgentMessageCommand{Method: "cometan", Jsonrpc: "2.0"}
+	commandToSend.Params.Name = "agent_message"
+	commandToSend.Params.Message = reply.String()
+	commandToSend.Params.ChatID = chatId
+	commandToSend.Params.ClientID = data.ClientID
+	commandToSend.Params.PrivateID = "auto-reply-" + newUUID()
+
+	commandToSend.ID = sender.nextRequestId()
+
+	outgoing := Outgoing{
+		RequestID: commandToSend.ID,
+		Command:   commandToSend.Params.Name,
+		ChatID:    chatId,
+		ClientID:  data.ClientID,
+		PrivateID: commandToSend.Params.PrivateID,
+		Request:   commandToSend,
+	}
+
+	err = sender.enqueue(outgoing)
+
+	if err != nil {
+		sender.reject(outgoing, err)
+		autoReplies.release(rule.ID, chatId)
+
+		return
+	}
+
+	countMetric("auto_replies", 1)
+
+	sender.log().WithFields(logrus.Fields{
+		"rule":    rule.ID,
+		"chat_id": chatId,
+	}).Info("Auto reply queued:")
+
+	err = publishServiceEvent(AutoRepliedEventParams{
+		Name:      "auto_replied",
+		ManagerID: sender.Id,
+		RuleID:    rule.ID,
+		ChatID:    chatId,
+		ClientID:  data.ClientID,
+		PrivateID: commandToSend.Params.PrivateID,
+		Message:   commandToSend.Params.Message,
+	})
+
+	if err != nil {
+		sender.log().WithFields(logrus.Fields{
+			"chat_id": chatId,
+			"error":   err,
+		}).Error("Failed to publish:")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoReplyMatchCooldowns(t *testing.T) {
+	config.AutoReply.Cooldown = 30 * time.Minute
+
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	manager := &Manager{Id: "42"}
+
+	engine := &AutoReplyEngine{
+		rules: []AutoReplyRule{
+			{ID: 1, Keywords: []string{"price"}, Cooldown: time.Minute},
+			{ID: 2},
+		},
+		cooldowns: make(map[string]time.Time),
+	}
+
+	steps := []struct {
+		name    string
+		chatId  int
+		text    string
+		at      time.Duration
+		release int
+		rule    int
+	}{
+		{"keyword rule fires", 1, "What is the PRICE?", 0, 0, 1},
+		{"keyword rule cooling down, fallback fires", 1, "price again", time.Second, 0, 2},
+		{"both rules cooling down", 1, "price", 2 * time.Second, 0, 0},
+		{"other chat is not affected", 2, "price", 3 * time.Second, 0, 1},
+		{"rule cooldown expired", 1, "price", 2 * time.Minute, 0, 1},
+		{"default cooldown still active", 1, "hello", 3 * time.Minute, 0, 0},
+		{"default cooldown expired", 1, "hello", 31 * time.Minute, 0, 2},
+		{"released cooldown fires again", 1, "hello", 32 * time.Minute, 2, 2},
+	}
+
+	for _, step := range steps {
+		if step.release != 0 {
+			engine.release(step.release, step.chatId)
+		}
+
+		rule := engine.match(manager, 839750, step.chatId, step.text, false, start.Add(step.at))
+		id := 0
+
+		if rule != nil {
+			id = rule.ID
+		}
+
+		if id != step.rule {
+			t.Errorf("%s: got rule %d, want %d", step.name, id, step.rule)
+		}
+	}
+}
+
+func TestAutoReplyRuleMatches(t *testing.T) {
+	at := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	manager := &Manager{Id: "42"}
+
+	tests := []struct {
+		name  string
+		rule  AutoReplyRule
+		site  int
+		text  string
+		first bool
+		want  bool
+	}{
+		{"any site", AutoReplyRule{}, 1, "hi", false, true},
+		{"other site", AutoReplyRule{SiteID: 2}, 1, "hi", false, false},
+		{"first message", AutoReplyRule{FirstMessage: true}, 1, "hi", true, true},
+		{"not first message", AutoReplyRule{FirstMessage: true}, 1, "hi", false, false},
+		{"keyword missing", AutoReplyRule{Keywords: []string{"refund"}}, 1, "hi", false, false},
+		{"outside hours", AutoReplyRule{Schedule: Schedule{Hours: "18:00-09:00"}}, 1, "hi", false, false},
+	}
+
+	for _, test := range tests {
+		got := test.rule.matches(manager, test.site, test.text, test.first, at)
+
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+type CannedPhrase struct {
+	ID     int      `json:"id"`
+	Phrase string   `json:"phrase"`
+	Tags   []string `json:"tags"`
+}
+
+type CannedPhrasesState struct {
+	request int
+	version interface{}
+}
+
+type CannedPhrasesResponse struct {
+	ID     int `json:"id"`
+	Result struct {
+		Version interface{}    `json:"version"`
+		Phrases []CannedPhrase `json:"phrases"`
+	} `json:"result"`
+}
+
+type CannedPhrasesChanged struct {
+	Params struct {
+		Name    string      `json:"name"`
+		Version interface{} `json:"version"`
+	} `json:"params"`
+}
+
+type CannedPhraseCommand struct {
+	ManagerID string `json:"managerId"`
+	Params    struct {
+		Name   string       `json:"name"`
+		Phrase CannedPhrase `json:"phrase"`
+	} `json:"params"`
+}
+
+type CannedPhraseRequestParams struct {
+	Name   string       `json:"name"`
+	Phrase CannedPhrase `json:"phrase"`
+}
+
+type CannedPhraseRequest struct {
+	ID      int                       `json:"id"`
+	Method  string                    `json:"method"`
+	Params  CannedPhraseRequestParams `json:"params"`
+	Jsonrpc string                    `json:"jsonrpc"`
+}
+
+type CannedPhrasesEventParams struct {
+	Name      string         `json:"name"`
+	ManagerID string         `json:"manager_id"`
+	SiteID    int            `json:"site_id"`
+	Phrases   []CannedPhrase `json:"phrases"`
+}
+
+type CannedPhraseResultParams struct {
+	Name      string       `json:"name"`
+	ManagerID string       `json:"manager_id"`
+	Command   string       `json:"command"`
+	RequestID int          `json:"request_id"`
+	Phrase    CannedPhrase `json:"phrase"`
+	Ok        bool         `json:"ok"`
+	Error     string       `json:"error,omitempty"`
+}
+
+var cannedPhraseRequests = map[string]string{
+	"canned_phrase_create": "canned_phrase_add",
+	"canned_phrase_update": "canned_phrase_edit",
+	"canned_phrase_delete": "canned_phrase_delete",
+}
+
+func isCannedPhraseCommand(name string) bool {
+	_, ok := cannedPhraseRequests[name]
+
+	return ok || name == "canned_phrases_list"
+}
+
+func (manager *Manager) requestCannedPhrases(version interface{}) {
+	manager.mu.Lock()
+	cannedPhrases := CannedPhrases{manager.nextRequestId(), "cometan", CannedPhrasesParams{"canned_phrases", 1, version}, "2.0"}
+	manager.cannedPhrases.request = cannedPhrases.ID
+	err := manager.sendJSON(cannedPhrases)
+	manager.mu.Unlock()
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Can`t write canned phrases request to socket:")
+	}
+}
+
+func (manager *Manager) handleCannedPhrases(detectServerMessage DetectServerMessage, message []byte) {
+	manager.mu.Lock()
+	state := manager.cannedPhrases
+	manager.mu.Unlock()
+
+	if detectServerMessage.Method == "" && state.request != 0 && detectServerMessage.ID == state.request {
+		response := CannedPhrasesResponse{}
+
+		err := json.Unmarshal(message, &response)
+
+		if err != nil {
+			manager.log().WithFields(logrus.Fields{
+				"error": err,
+			}).Error("Can`t decode canned phrases response from socket:")
+
+			return
+		}
+
+		if response.Result.Phrases == nil {
+			return
+		}
+
+		err = saveCannedPhrases(config.JivoSite.SiteID, response.Result.Version, response.Result.Phrases)
+
+		if err != nil {
+			manager.log().WithFields(logrus.Fields{
+				"err": err,
+			}).Error("Manager can`t save canned phrases:")
+
+			return
+		}
+
+		manager.mu.Lock()
+		manager.cannedPhrases.version = response.Result.Version
+		manager.mu.Unlock()
+
+		manager.log().WithFields(logrus.Fields{
+			"count":   len(response.Result.Phrases),
+			"version": response.Result.Version,
+		}).Info("Canned phrases synchronized:")
+
+		return
+	}
+
+	if detectServerMessage.Method != "handle" || !strings.Contains(string(message), "canned_phrases") {
+		return
+	}
+
+	changed := CannedPhrasesChanged{}
+
+	err := json.Unmarshal(message, &changed)
+
+	if err != nil || !strings.HasPrefix(changed.Params.Name, "canned_phrases") || changed.Params.Version == nil {
+		return
+	}
+
+	if fmt.Sprint(changed.Params.Version) != fmt.Sprint(state.version) {
+		go manager.requestCannedPhrases(state.version)
+	}
+}
+
+func (cannedPhraseCommand *CannedPhraseCommand) validate() error {
+	phrase := cannedPhraseCommand.Params.Phrase
+
+	switch cannedPhraseCommand.Params.Name {
+	case "canned_phrase_create":
+		if strings.TrimSpace(phrase.Phrase) == "" {
+			return errors.New("phrase text is required")
+		}
+	case "canned_phrase_update":
+		if phrase.ID == 0 {
+			return errors.New("phrase id is required")
+		}
+
+		if strings.TrimSpace(phrase.Phrase) == "" {
+			return errors.New("phrase text is required")
+		}
+	case "canned_phrase_delete":
+		if phrase.ID == 0 {
+			return errors.New("phrase id is required")
+		}
+	}
+
+	return nil
+}
+
+func (manager *Manager) cannedPhraseCommand(command []byte) error {
+	cannedPhraseCommand := CannedPhraseCommand{}
+
+	err := json.Unmarshal(command, &cannedPhraseCommand)
+
+	if err != nil {
+		return err
+	}
+
+	if cannedPhraseCommand.Params.Name == "canned_phrases_list" {
+		phrases, err := getCannedPhrasesFromDb(config.JivoSite.SiteID)
+
+		if err != nil {
+			return err
+		}
+
+		return publishServiceEvent(CannedPhrasesEventParams{
+			Name:      "canned_phrases",
+			ManagerID: manager.Id,
+			SiteID:    config.JivoSite.SiteID,
+			Phrases:   phrases,
+		})
+	}
+
+	err = cannedPhraseCommand.validate()
+
+	if err != nil {
+		return err
+	}
+
+	request := CannedPhraseRequest{
+		ID:      manager.nextRequestId(),
+		Method:  "cometan",
+		Params:  CannedPhraseRequestParams{cannedPhraseRequests[cannedPhraseCommand.Params.Name], cannedPhraseCommand.Params.Phrase},
+		Jsonrpc: "2.0",
+	}
+
+	return manager.enqueue(Outgoing{
+		RequestID: request.ID,
+		Command:   cannedPhraseCommand.Params.Name,
+		Request:   request,
+		Pending:   PendingRequest{Phrase: &cannedPhraseCommand.Params.Phrase},
+	})
+}
+
+func (manager *Manager) cannedPhraseResult(pending PendingRequest, response RpcResponse) {
+	result := CannedPhraseResultParams{
+		Name:      "canned_phrase_result",
+		ManagerID: manager.Id,
+		Command:   pending.Command,
+		RequestID: response.ID,
+		Phrase:    *pending.Phrase,
+		Ok:        response.Error == nil,
+	}
+
+	if response.Error != nil {
+		result.Error = response.Error.Message
+	} else {
+		manager.mu.Lock()
+		version := manager.cannedPhrases.version
+		manager.mu.Unlock()
+
+		go manager.requestCannedPhrases(version)
+	}
+
+	err := publishServiceEvent(result)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"command": pending.Command,
+			"error":   err,
+		}).Error("Failed to publish:")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type ChatCommand struct {
+	ManagerID string `json:"managerId"`
+	Params    struct {
+		Name      string `json:"name"`
+		ChatID    int    `json:"chat_id"`
+		ClientID  int    `json:"client_id"`
+		AgentID   int    `json:"agent_id"`
+		MessageID int    `json:"message_id"`
+		Message   string `json:"message"`
+	} `json:"params"`
+}
+
+type ChatRequestParams struct {
+	Name      string `json:"name"`
+	ChatID    int    `json:"chat_id"`
+	ClientID  int    `json:"client_id,omitempty"`
+	AgentID   int    `json:"agent_id,omitempty"`
+	MessageID int    `json:"message_id,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+type ChatRequest struct {
+	ID      int               `json:"id"`
+	Method  string            `json:"method"`
+	Params  ChatRequestParams `json:"params"`
+	Jsonrpc string            `json:"jsonrpc"`
+}
+
+var chatRequests = map[string]string{
+	"chat_close":    "chat_finish",
+	"chat_transfer": "chat_redirect",
+	"chat_invite":   "chat_invite_agent",
+	"chat_read":     "chat_mark_read",
+}
+
+func isChatCommand(name string) bool {
+	_, ok := chatRequests[name]
+
+	return ok
+}
+
+func (chatCommand *ChatCommand) validate() error {
+	if chatCommand.Params.ChatID <= 0 {
+		return errors.New("chat_id is required")
+	}
+
+	switch chatCommand.Params.Name {
+	case "chat_transfer", "chat_invite":
+		if chatCommand.Params.AgentID <= 0 {
+			return errors.New("agent_id is required")
+		}
+	case "chat_read":
+		if chatCommand.Params.MessageID < 0 {
+			return errors.New("message_id is invalid")
+		}
+	}
+
+	return nil
+}
+
+func (manager *Manager) chatCommand(command []byte) (int, error) {
+	chatCommand := ChatCommand{}
+
+	err := json.Unmarshal(command, &chatCommand)
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = chatCommand.validate()
+
+	if err != nil {
+		return chatCommand.Params.ChatID, err
+	}
+
+	err = manager.checkChat(chatCommand.Params.ChatID)
+
+	if err != nil {
+		return chatCommand.Params.ChatID, err
+	}
+
+	manager.mu.Lock()
+	request := ChatRequest{
+		ID:     manager.nextRequestId(),
+		Method: "cometan",
+		Params: ChatRequestParams{
+			Name:      chatRequests[chatCommand.Params.Name],
+			ChatID:    chatCommand.Params.ChatID,
+			ClientID:  chatCommand.Params.ClientID,
+			AgentID:   chatCommand.Params.AgentID,
+			MessageID: chatCommand.Params.MessageID,
+			Message:   chatCommand.Params.Message,
+		},
+		Jsonrpc: "2.0",
+	}
+	manager.mu.Unlock()
+
+	err = manager.enqueue(Outgoing{
+		RequestID: request.ID,
+		Command:   chatCommand.Params.Name,
+		ChatID:    chatCommand.Params.ChatID,
+		ClientID:  chatCommand.Params.ClientID,
+		Request:   request,
+	})
+
+	return chatCommand.Params.ChatID, err
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ChatOpen        = "open"
+	ChatClosed      = "closed"
+	ChatTransferred = "transferred"
+)
+
+type Chat struct {
+	ChatID        int        `json:"chat_id"`
+	ClientID      int        `json:"client_id"`
+	Visitor       string     `json:"visitor,omitempty"`
+	State         string     `json:"state"`
+	AcceptedAt    time.Time  `json:"accepted_at"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+	ClosedAt      *time.Time `json:"closed_at,omitempty"`
+}
+
+type ChatTracker struct {
+	chats    map[int]*Chat
+	seen     map[string]time.Time
+	prunedAt time.Time
+	mu       sync.RWMutex
+}
+
+type ChatListCommand struct {
+	ManagerID string `json:"managerId"`
+	Params    struct {
+		Name string `json:"name"`
+		All  bool   `json:"all"`
+	} `json:"params"`
+}
+
+type ChatsEventParams struct {
+	Name      string `json:"name"`
+	ManagerID string `json:"manager_id"`
+	Chats     []Chat `json:"chats"`
+}
+
+var chatEvents = map[string]string{
+	"chat_accepted":    "accepted",
+	"chat_started":     "accepted",
+	"client_message":   "message",
+	"agent_message":    "message",
+	"chat_finished":    "closed",
+	"chat_closed":      "closed",
+	"chat_redirected":  "transferred",
+	"chat_transferred": "transferred",
+}
+
+func newChatTracker() *ChatTracker {
+	return &ChatTracker{chats: make(map[int]*Chat), seen: make(map[string]time.Time)}
+}
+
+func intParam(params map[string]interface{}, key string) int {
+	switch value := params[key].(type) {
+	case float64:
+		return int(value)
+	case string:
+		number, _ := strconv.Atoi(value)
+		return number
+	}
+
+	return 0
+}
+
+func (tracker *ChatTracker) get(chatId int) (Chat, bool) {
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	chat, ok := tracker.chats[chatId]
+
+	if !ok {
+		return Chat{}, false
+	}
+
+	return *chat, true
+}
+
+func (tracker *ChatTracker) list(all bool) []Chat {
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	chats := []Chat{}
+
+	for _, chat := range tracker.chats {
+		if all || chat.State == ChatOpen {
+			chats = append(chats, *chat)
+		}
+	}
+
+	sort.Slice(chats, func(i, j int) bool {
+		return chats[i].ChatID < chats[j].ChatID
+	})
+
+	return chats
+}
+
+func (tracker *ChatTracker) apply(event string, params map[string]interface{}, at time.Time) (Chat, bool) {
+	kind, ok := chatEvents[event]
+	chatId := intParam(params, "chat_id")
+
+	if !ok || chatId <= 0 {
+		return Chat{}, false
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	chat, ok := tracker.chats[chatId]
+
+	if !ok {
+		chat = &Chat{ChatID: chatId, State: ChatOpen, AcceptedAt: at}
+		tracker.chats[chatId] = chat
+	}
+
+	if clientId := intParam(params, "client_id"); clientId > 0 {
+		chat.ClientID = clientId
+	}
+
+	if visitor, ok := params["client_name"].(string); ok && visitor != "" {
+		chat.Visitor = visitor
+	}
+
+	switch kind {
+	case "accepted":
+		chat.State = ChatOpen
+		chat.AcceptedAt = at
+		chat.ClosedAt = nil
+	case "message":
+		if chat.LastMessageAt == nil || at.After(*chat.LastMessageAt) {
+			chat.LastMessageAt = &at
+		}
+
+		if messageId := stringParam(params, "message_id", "id"); messageId != "" {
+			tracker.seen[dedupKey(chatId, messageId)] = at
+		}
+	case "closed":
+		chat.State = ChatClosed
+		chat.ClosedAt = &at
+	case "transferred":
+		chat.State = ChatTransferred
+		chat.ClosedAt = &at
+	}
+
+	tracker.prune(at)
+
+	return *chat, true
+}
+
+func (tracker *ChatTracker) prune(at time.Time) {
+	if at.Sub(tracker.prunedAt) < time.Minute {
+		return
+	}
+
+	tracker.prunedAt = at
+
+	for id, chat := range tracker.chats {
+		if chat.ClosedAt != nil && at.Sub(*chat.ClosedAt) > config.Chats.Retention {
+			delete(tracker.chats, id)
+		}
+	}
+
+	for key, seenAt := range tracker.seen {
+		if at.Sub(seenAt) > config.Chats.Retention {
+			delete(tracker.seen, key)
+		}
+	}
+}
+
+func (tracker *ChatTracker) check(chatId int) error {
+	chat, ok := tracker.get(chatId)
+
+	if !ok {
+		if config.Chats.RejectUnknown {
+			return errors.New("unknown chat")
+		}
+
+		return nil
+	}
+
+	if chat.State != ChatOpen && config.Chats.RejectClosed {
+		return errors.New("chat is " + chat.State)
+	}
+
+	return nil
+}
+
+func (manager *Manager) loadChats() {
+	tracker := newChatTracker()
+	manager.chats = tracker
+
+	if MySQL == nil {
+		return
+	}
+
+	chats, err := getOpenChats(manager.Id)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Manager can`t load chats:")
+
+		return
+	}
+
+	tracker.mu.Lock()
+	for i := range chats {
+		tracker.chats[chats[i].ChatID] = &chats[i]
+	}
+	tracker.mu.Unlock()
+
+	manager.log().WithFields(logrus.Fields{
+		"chats": len(chats),
+	}).Info("Manager chats restored:")
+}
+
+func (manager *Manager) trackChat(event string, params map[string]interface{}, at time.Time) {
+	if manager.chats == nil || params == nil {
+		return
+	}
+
+	chat, ok := manager.chats.apply(event, params, at)
+
+	if !ok || MySQL == nil {
+		return
+	}
+
+	err := saveChat(manager.Id, chat)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"chat_id": chat.ChatID,
+			"err":     err,
+		}).Error("Manager can`t store chat:")
+	}
+}
+
+func (manager *Manager) checkChat(chatId int) error {
+	if manager.chats == nil {
+		return nil
+	}
+
+	return manager.chats.check(chatId)
+}
+
+func (manager *Manager) chatListCommand(command []byte) error {
+	chatListCommand := ChatListCommand{}
+
+	err := json.Unmarshal(command, &chatListCommand)
+
+	if err != nil {
+		return err
+	}
+
+	chats := []Chat{}
+
+	if manager.chats != nil {
+		chats = manager.chats.list(chatListCommand.Params.All)
+	}
+
+	return publishServiceEvent(ChatsEventParams{
+		Name:      "chats",
+		ManagerID: manager.Id,
+		Chats:     chats,
+	})
+}
+
+func (server *Server) chatsHandler(w http.ResponseWriter, r *http.Request) {
+	manager, ok := server.manager(r.URL.Query().Get("manager"))
+
+	if !ok || manager.chats == nil {
+		http.Error(w, "manager is offline", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(manager.chats.list(r.URL.Query().Get("all") == "true"))
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: micro-service-jivosite [command] [flags] [args]
+
+Commands:
+  serve                              run the daemon (default)
+  check-config                       validate settings and print them
+  login-test <managerId> [-force]    log a manager in to JivoSite and report the result
+  send <managerId> <chatId> <text> [-force]
+                                     send a one-off message to a chat
+  set-offline                        mark every manager offline in MySQL
+  encrypt-credentials                encrypt stored passwords with the first CREDENTIALS_KEYS key
+  replay <capture.jsonl>             feed a recorded capture through the reader and print what would be published
+
+Every setting can be passed as a flag, e.g. -log-level info.
+`
+
+var secretSettings = map[string]bool{
+	"RABBITMQ_ERP_PASS":        true,
+	"MYSQL_DATABASE_PASSWORD":  true,
+	"LOGTOEMAIL_SMTP_PASSWORD": true,
+	"MEDIA_S3_SECRET":          true,
+	"CREDENTIALS_KEYS":         true,
+}
+
+func run(args []string) int {
+	command := "serve"
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	force := false
+	rest := []string{}
+
+	for _, arg := range args {
+		if (command == "login-test" || command == "send") && (arg == "-force" || arg == "--force") {
+			force = true
+			continue
+		}
+
+		rest = append(rest, arg)
+	}
+
+	cfg, args, err := loadConfig(rest)
+
+	if command == "check-config" {
+		return checkConfig(cfg, err)
+	}
+
+	if command == "replay" && cfg != nil {
+		config = cfg
+
+		if len(args) != 1 {
+			fmt.Fprint(os.Stderr, usage)
+			return 2
+		}
+
+		err = replay(args[0], os.Stdout)
+
+		if err != nil {
+			return report(err)
+		}
+
+		return 0
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", "Invalid configuration", err)
+		return 2
+	}
+
+	config = cfg
+
+	switch command {
+	case "serve":
+		serve()
+		return 0
+	case "login-test":
+		if len(args) != 1 {
+			fmt.Fprint(os.Stderr, usage)
+			return 2
+		}
+
+		return report(loginTest(args[0], force))
+	case "send":
+		if len(args) != 3 {
+			fmt.Fprint(os.Stderr, usage)
+			return 2
+		}
+
+		chatId, err := strconv.Atoi(args[1])
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", "Invalid chat id", err)
+			return 2
+		}
+
+		return report(sendMessage(args[0], chatId, args[2], force))
+	case "set-offline":
+		return report(setOffline())
+	case "encrypt-credentials":
+		return report(migrateCredentials())
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+
+	return 2
+}
+
+func report(err error) int {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL: %s\n", err)
+		return 1
+	}
+
+	fmt.Println("OK")
+
+	return 0
+}
+
+func checkConfig(cfg *Config, err error) int {
+	if cfg != nil {
+		for _, s := range cfg.settings() {
+			var value string
+
+			switch target := s.Target.(type) {
+			case *string:
+				value = *target
+			case *int:
+				value = strconv.Itoa(*target)
+			case *bool:
+				value = strconv.FormatBool(*target)
+			case *time.Duration:
+				value = target.String()
+			case *[]string:
+				value = strings.Join(*target, ",")
+			}
+
+			if secretSettings[s.Env] && value != "" {
+				value = "***"
+			}
+
+			fmt.Printf("%s=%s\n", s.Env, value)
+		}
+	}
+
+	return report(err)
+}
+
+func setOffline() error {
+	err := connectMySQL()
+
+	if err != nil {
+		return err
+	}
+
+	defer MySQL.Close()
+
+	return selOfflineAll()
+}
+
+func migrateCredentials() error {
+	err := connectMySQL()
+
+	if err != nil {
+		return err
+	}
+
+	defer MySQL.Close()
+
+	updated, err := encryptCredentials()
+
+	fmt.Printf("passwords encrypted: %d\n", updated)
+
+	return err
+}
+
+func (manager *Manager) waitResponse(id int, timeout time.Duration) (*RpcResponse, error) {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		manager.connection.SetReadDeadline(deadline)
+
+		_, message, err := manager.connection.ReadMessage()
+
+		if err != nil {
+			return nil, err
+		}
+
+		detectServerMessage := DetectServerMessage{}
+
+		if json.Unmarshal(message, &detectServerMessage) != nil || detectServerMessage.ID != id || detectServerMessage.Method != "" {
+			continue
+		}
+
+		response := &RpcResponse{}
+
+		err = json.Unmarshal(message, response)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if response.Error != nil {
+			return response, fmt.Errorf("rpc error %d: %s", response.Error.Code, response.Error.Message)
+		}
+
+		return response, nil
+	}
+
+	return nil, errors.New("timeout waiting for response")
+}
+
+func openSession(managerId string) (*Manager, error) {
+	manager := &Manager{Id: managerId, presence: PresenceAvailable}
+
+	login, password, err := getCredentials(managerId)
+
+	if err != nil {
+		return nil, fmt.Errorf("get credentials: %s", err)
+	}
+
+	fmt.Println("credentials: found")
+
+	response, err := getApiKey(login, password)
+
+	if err != nil {
+		return nil, fmt.Errorf("login: %s", err)
+	}
+
+	manager.SuccessLoginResponse = response
+
+	fmt.Printf("login: ok, chatserver %s\n", response.EndpointList.Chatserver)
+
+	response, err = refreshApiKey(manager)
+
+	if err != nil {
+		return nil, fmt.Errorf("refresh token: %s", err)
+	}
+
+	manager.SuccessLoginResponse = response
+
+	fmt.Println("refresh token: ok")
+
+	err = manager.dialSocket()
+
+	if err != nil {
+		return nil, fmt.Errorf("connect socket: %s", err)
+	}
+
+	manager.subscribe()
+
+	id, err := manager.writeAuth()
+
+	if err == nil {
+		_, err = manager.waitResponse(id, config.Timeouts.HTTP)
+	}
+
+	if err != nil {
+		manager.connection.Close()
+		return nil, fmt.Errorf("socket auth: %s", err)
+	}
+
+	fmt.Println("socket auth: ok")
+
+	return manager, nil
+}
+
+func checkTakeover(managerId string, force bool) error {
+	if force {
+		return nil
+	}
+
+	online, err := getStatus(managerId)
+
+	if err != nil {
+		return err
+	}
+
+	if online {
+		return fmt.Errorf("manager %s is online, this would kick the live session; pass -force to take it over", managerId)
+	}
+
+	if !config.Cluster.Enabled {
+		return nil
+	}
+
+	owner, err := getLeaseOwner(managerId)
+
+	if err != nil {
+		return err
+	}
+
+	if owner != "" {
+		return fmt.Errorf("manager %s is leased by %s, this would kick the live session; pass -force to take it over", managerId, owner)
+	}
+
+	return nil
+}
+
+func loginTest(managerId string, force bool) error {
+	err := connectMySQL()
+
+	if err != nil {
+		return err
+	}
+
+	defer MySQL.Close()
+
+	err = checkTakeover(managerId, force)
+
+	if err != nil {
+		return err
+	}
+
+	manager, err := openSession(managerId)
+
+	if err != nil {
+		return err
+	}
+
+	return manager.connection.Close()
+}
+
+func sendMessage(managerId string, chatId int, text string, force bool) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("message text is required")
+	}
+
+	err := connectMySQL()
+
+	if err != nil {
+		return err
+	}
+
+	defer MySQL.Close()
+
+	err = checkTakeover(managerId, force)
+
+	if err != nil {
+		return err
+	}
+
+	manager, err := openSession(managerId)
+
+	if err != nil {
+		return err
+	}
+
+	defer manager.connection.Close()
+
+	commandToSend := AgentMessageCommand{}
+	commandToSend.ID = manager.nextRequestId()
+	commandToSend.Method = "cometan"
+	commandToSend.Jsonrpc = "2.0"
+	commandToSend.Params.Name = "agent_message"
+	commandToSend.Params.ChatID = chatId
+	commandToSend.Params.Message = text
+
+	err = manager.sendJSON(commandToSend)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = manager.waitResponse(commandToSend.ID, config.Timeouts.HTTP)
+
+	return err
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"os"
+	"time"
+)
+
+func nodeId() string {
+	if config.Cluster.NodeID != "" {
+		return config.Cluster.NodeID
+	}
+
+	hostname, err := os.Hostname()
+
+	if err != nil || hostname == "" {
+		hostname = newUUID()
+	}
+
+	config.Cluster.NodeID = hostname
+
+	return hostname
+}
+
+func nodeQueue(queue string, node string) string {
+	return queue + "." + node
+}
+
+func declareNodeQueue(queue string) (string, error) {
+	name := nodeQueue(queue, nodeId())
+
+	_, err := AMQPChannel.QueueDeclare(
+		name,
+		false,
+		true,
+		false,
+		false,
+		nil,
+	)
+
+	return name, err
+}
+
+func forwardToNode(queue string, body []byte) error {
+	return AMQPChannel.Publish(
+		"",
+		queue,
+		false,
+		false,
+		amqp.Publishing{
+			DeliveryMode: amqp.Transient,
+			ContentType:  "application/json",
+			Body:         body,
+			Timestamp:    time.Now(),
+		})
+}
+
+func routeToOwner(queue string, managerId string, body []byte) bool {
+	if !config.Cluster.Enabled || managerId == "" {
+		return false
+	}
+
+	owner, err := getLeaseOwner(managerId)
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"manager": managerId,
+			"err":     err,
+		}).Error("Can`t get manager lease:")
+
+		return false
+	}
+
+	if owner == "" || owner == nodeId() {
+		return false
+	}
+
+	err = forwardToNode(nodeQueue(queue, owner), body)
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"manager": managerId,
+			"owner":   owner,
+			"err":     err,
+		}).Error("Can`t forward message to lease owner:")
+
+		return false
+	}
+
+	logger.WithFields(logrus.Fields{
+		"manager": managerId,
+		"owner":   owner,
+		"queue":   queue,
+	}).Debug("Message forwarded to lease owner:")
+
+	return true
+}
+
+func commandManagerId(command []byte) string {
+	whatCommand := WhatCommand{}
+	json.Unmarshal(command, &whatCommand)
+
+	return whatCommand.ManagerId
+}
+
+func (server *Server) claim(managerId string) bool {
+	if !config.Cluster.Enabled {
+		return true
+	}
+
+	claimed, err := claimLease(managerId, nodeId(), config.Cluster.LeaseTTL)
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"manager": managerId,
+			"err":     err,
+		}).Error("Can`t claim manager lease:")
+
+		return false
+	}
+
+	return claimed
+}
+
+func (server *Server) release(managerId string) {
+	if !config.Cluster.Enabled {
+		return
+	}
+
+	err := releaseLease(managerId, nodeId())
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"manager": managerId,
+			"err":     err,
+		}).Error("Can`t release manager lease:")
+	}
+}
+
+func (server *Server) leases() {
+	logger.WithFields(logrus.Fields{
+		"node": nodeId(),
+	}).Info("Server start lease heartbeat:")
+
+	ticker := time.NewTicker(config.Cluster.LeaseHeartbeat)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := renewLeases(nodeId(), config.Cluster.LeaseTTL)
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"err": err,
+			}).Error("Can`t renew manager leases:")
+
+			continue
+		}
+
+		owned, err := getOwnedLeases(nodeId())
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"err": err,
+			}).Error("Can`t get manager leases:")
+
+			continue
+		}
+
+		server.owned <- owned
+
+		expired, err := getExpiredLeases()
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"err": err,
+			}).Error("Can`t get expired manager leases:")
+
+			continue
+		}
+
+		for _, managerId := range expired {
+			if !server.claim(managerId) {
+				continue
+			}
+
+			logger.WithFields(logrus.Fields{
+				"manager": managerId,
+				"node":    nodeId(),
+			}).Warn("Manager lease taken over:")
+
+			server.online <- &Manager{Id: managerId, reason: "lease takeover"}
+		}
+	}
+}
+
+func (server *Server) dropLost(owned []string) {
+	leased := make(map[string]bool)
+
+	for _, managerId := range owned {
+		leased[managerId] = true
+	}
+
+	for managerId, manager := range server.managers {
+		if leased[managerId] {
+			continue
+		}
+
+		owner, err := getLeaseOwner(managerId)
+
+		if err != nil || owner == nodeId() {
+			continue
+		}
+
+		logger.WithFields(logrus.Fields{
+			"manager": managerId,
+		}).Warn("Manager lease lost:")
+
+		close(manager.quit)
+		manager.connection.Close()
+		manager.stopRecording()
+		server.remove(managerId)
+
+		manager.publishStatus(StatusDisconnected, "lease lost")
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AMQPConfig struct {
+	Host  string
+	Port  int
+	Login string
+	Pass  string
+	VHost string
+}
+
+type MySQLConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	DB       string
+}
+
+type SMTPConfig struct {
+	AppName  string
+	Host     string
+	Port     int
+	From     string
+	To       string
+	Username string
+	Password string
+}
+
+type JivoSiteConfig struct {
+	SiteID int
+	ApiURL string
+	AppURL string
+}
+
+type QueueConfig struct {
+	ManagerStatus  string
+	ManagerCommand string
+	ErpMessages    string
+}
+
+type TimeoutConfig struct {
+	HTTP   time.Duration
+	Ping   time.Duration
+	Mirror time.Duration
+}
+
+type StartupConfig struct {
+	SetOffline bool
+}
+
+type FeatureConfig struct {
+	CannedPhrases bool
+	MediaMirror   bool
+}
+
+type LogConfig struct {
+	Level           string
+	Format          string
+	HeartbeatSample int
+	Verbose         bool
+}
+
+type AlertConfig struct {
+	KeyInterval  time.Duration
+	Digest       time.Duration
+	ResolveAfter time.Duration
+	Threshold    int
+}
+
+type AttachmentConfig struct {
+	MaxFileSize  int
+	MaxPixels    int
+	AllowedTypes []string
+}
+
+type MediaConfig struct {
+	Storage    string
+	Path       string
+	URL        string
+	S3Endpoint string
+	S3Region   string
+	S3Bucket   string
+	S3Key      string
+	S3Secret   string
+	Workers    int
+	Queue      int
+}
+
+type RecordConfig struct {
+	Dir      string
+	Managers []string
+}
+
+type CredentialsConfig struct {
+	Keys             []string
+	RequireEncrypted bool
+}
+
+type SessionConfig struct {
+	Persist bool
+	MaxAge  time.Duration
+}
+
+type ClusterConfig struct {
+	Enabled        bool
+	NodeID         string
+	LeaseTTL       time.Duration
+	LeaseHeartbeat time.Duration
+}
+
+type ReconnectConfig struct {
+	Attempts int
+	Delay    time.Duration
+}
+
+type RateLimitConfig struct {
+	ManagerPerMinute int
+	ManagerBurst     int
+	ChatPerMinute    int
+	ChatBurst        int
+	QueueSize        int
+}
+
+type MetricsConfig struct {
+	Addr string
+}
+
+type DedupConfig struct {
+	Window time.Duration
+}
+
+type ExchangeConfig struct {
+	Name      string
+	Prefix    string
+	BindQueue bool
+}
+
+type CommandConfig struct {
+	Workers  int
+	Prefetch int
+}
+
+type ChatsConfig struct {
+	RejectClosed  bool
+	RejectUnknown bool
+	Retention     time.Duration
+	HistoryLimit  int
+	Reconcile     bool
+}
+
+type AutoAcceptConfig struct {
+	Enabled  bool
+	MaxChats int
+	Hours    string
+	Days     []string
+	Timezone string
+	Events   []string
+}
+
+type AutoReplyConfig struct {
+	Enabled  bool
+	Refresh  time.Duration
+	Cooldown time.Duration
+	Timezone string
+}
+
+type Config struct {
+	AMQP        AMQPConfig
+	MySQL       MySQLConfig
+	SMTP        SMTPConfig
+	JivoSite    JivoSiteConfig
+	Queues      QueueConfig
+	Timeouts    TimeoutConfig
+	Startup     StartupConfig
+	Features    FeatureConfig
+	Log         LogConfig
+	Alert       AlertConfig
+	Attachment  AttachmentConfig
+	Media       MediaConfig
+	Record      RecordConfig
+	Credentials CredentialsConfig
+	Session     SessionConfig
+	Cluster     ClusterConfig
+	Reconnect   ReconnectConfig
+	RateLimit   RateLimitConfig
+	Metrics     MetricsConfig
+	Dedup       DedupConfig
+	Exchange    ExchangeConfig
+	Commands    CommandConfig
+	Chats       ChatsConfig
+	AutoAccept  AutoAcceptConfig
+	AutoReply   AutoReplyConfig
+}
+
+type setting struct {
+	Env     string
+	Default string
+	Target  interface{}
+}
+
+var config = defaultConfig()
+
+func (config *Config) settings() []setting {
+	return []setting{
+		{"RABBITMQ_ERP_HOST", "", &config.AMQP.Host},
+		{"RABBITMQ_ERP_PORT", "5672", &config.AMQP.Port},
+		{"RABBITMQ_ERP_LOGIN", "", &config.AMQP.Login},
+		{"RABBITMQ_ERP_PASS", "", &config.AMQP.Pass},
+		{"RABBITMQ_ERP_VHOST", "/", &config.AMQP.VHost},
+
+		{"MYSQL_DATABASE_HOST", "", &config.MySQL.Host},
+		{"MYSQL_DATABASE_PORT", "3306", &config.MySQL.Port},
+		{"MYSQL_DATABASE_USER", "", &config.MySQL.User},
+		{"MYSQL_DATABASE_PASSWORD", "", &config.MySQL.Password},
+		{"MYSQL_DATABASE_DB", "", &config.MySQL.DB},
+
+		{"LOGTOEMAIL_APP_NAME", "micro-service-jivosite", &config.SMTP.AppName},
+		{"LOGTOEMAIL_SMTP_HOST", "", &config.SMTP.Host},
+		{"LOGTOEMAIL_SMTP_PORT", "25", &config.SMTP.Port},
+		{"LOGTOEMAIL_SMTP_FROM", "", &config.SMTP.From},
+		{"LOGTOEMAIL_SMTP_TO", "", &config.SMTP.To},
+		{"LOGTOEMAIL_SMTP_USERNAME", "", &config.SMTP.Username},
+		{"LOGTOEMAIL_SMTP_PASSWORD", "", &config.SMTP.Password},
+
+		{"JIVOSITE_SITE_ID", "839750", &config.JivoSite.SiteID},
+		{"JIVOSITE_API_URL", "https://api.jivosite.com", &config.JivoSite.ApiURL},
+		{"JIVOSITE_APP_URL", "https://app.jivosite.com", &config.JivoSite.AppURL},
+
+		{"QUEUE_MANAGER_STATUS", "erp_chat_manager_status", &config.Queues.ManagerStatus},
+		{"QUEUE_MANAGER_COMMAND", "erp_chat_manager_command", &config.Queues.ManagerCommand},
+		{"QUEUE_ERP_MESSAGES", "chat_to_erp_handle_messages", &config.Queues.ErpMessages},
+
+		{"EXCHANGE_ERP_EVENTS", "", &config.Exchange.Name},
+		{"EXCHANGE_ROUTING_PREFIX", "jivosite", &config.Exchange.Prefix},
+		{"EXCHANGE_BIND_QUEUE", "true", &config.Exchange.BindQueue},
+
+		{"COMMAND_WORKERS", "8", &config.Commands.Workers},
+		{"COMMAND_PREFETCH", "32", &config.Commands.Prefetch},
+
+		{"CHATS_REJECT_CLOSED", "true", &config.Chats.RejectClosed},
+		{"CHATS_REJECT_UNKNOWN", "false", &config.Chats.RejectUnknown},
+		{"CHATS_RETENTION", "1h", &config.Chats.Retention},
+		{"CHATS_HISTORY_LIMIT", "100", &config.Chats.HistoryLimit},
+		{"CHATS_RECONCILE", "true", &config.Chats.Reconcile},
+
+		{"AUTO_ACCEPT_ENABLED", "false", &config.AutoAccept.Enabled},
+		{"AUTO_ACCEPT_MAX_CHATS", "3", &config.AutoAccept.MaxChats},
+		{"AUTO_ACCEPT_HOURS", "", &config.AutoAccept.Hours},
+		{"AUTO_ACCEPT_DAYS", "", &config.AutoAccept.Days},
+		{"AUTO_ACCEPT_TIMEZONE", "UTC", &config.AutoAccept.Timezone},
+		{"AUTO_ACCEPT_EVENTS", "chat_offer,chat_request", &config.AutoAccept.Events},
+
+		{"AUTO_REPLY_ENABLED", "false", &config.AutoReply.Enabled},
+		{"AUTO_REPLY_REFRESH", "1m", &config.AutoReply.Refresh},
+		{"AUTO_REPLY_COOLDOWN", "30m", &config.AutoReply.Cooldown},
+		{"AUTO_REPLY_TIMEZONE", "UTC", &config.AutoReply.Timezone},
+
+		{"TIMEOUT_HTTP", "30s", &config.Timeouts.HTTP},
+		{"TIMEOUT_PING", "10s", &config.Timeouts.Ping},
+		{"TIMEOUT_MIRROR", "30s", &config.Timeouts.Mirror},
+
+		{"STARTUP_SET_OFFLINE", "false", &config.Startup.SetOffline},
+
+		{"FEATURE_CANNED_PHRASES", "true", &config.Features.CannedPhrases},
+		{"FEATURE_MEDIA_MIRROR", "true", &config.Features.MediaMirror},
+
+		{"LOG_LEVEL", "debug", &config.Log.Level},
+		{"LOG_FORMAT", "text", &config.Log.Format},
+		{"LOG_HEARTBEAT_SAMPLE", "30", &config.Log.HeartbeatSample},
+		{"LOG_VERBOSE", "false", &config.Log.Verbose},
+
+		{"ALERT_KEY_INTERVAL", "30m", &config.Alert.KeyInterval},
+		{"ALERT_DIGEST_INTERVAL", "5m", &config.Alert.Digest},
+		{"ALERT_RESOLVE_AFTER", "10m", &config.Alert.ResolveAfter},
+		{"ALERT_THRESHOLD", "3", &config.Alert.Threshold},
+
+		{"ATTACHMENT_MAX_FILE_SIZE", "10485760", &config.Attachment.MaxFileSize},
+		{"ATTACHMENT_MAX_PIXELS", "25000000", &config.Attachment.MaxPixels},
+		{"ATTACHMENT_ALLOWED_TYPES", "image/jpeg,image/png,application/pdf", &config.Attachment.AllowedTypes},
+
+		{"MEDIA_STORAGE", "", &config.Media.Storage},
+		{"MEDIA_STORAGE_PATH", "", &config.Media.Path},
+		{"MEDIA_STORAGE_URL", "", &config.Media.URL},
+		{"MEDIA_S3_ENDPOINT", "", &config.Media.S3Endpoint},
+		{"MEDIA_S3_REGION", "", &config.Media.S3Region},
+		{"MEDIA_S3_BUCKET", "", &config.Media.S3Bucket},
+		{"MEDIA_S3_KEY", "", &config.Media.S3Key},
+		{"MEDIA_S3_SECRET", "", &config.Media.S3Secret},
+		{"MEDIA_MIRROR_WORKERS", "2", &config.Media.Workers},
+		{"MEDIA_MIRROR_QUEUE", "100", &config.Media.Queue},
+
+		{"RECORD_DIR", "", &config.Record.Dir},
+		{"RECORD_MANAGERS", "", &config.Record.Managers},
+
+		{"CREDENTIALS_KEYS", "", &config.Credentials.Keys},
+		{"CREDENTIALS_REQUIRE_ENCRYPTED", "false", &config.Credentials.RequireEncrypted},
+
+		{"SESSION_PERSIST", "true", &config.Session.Persist},
+		{"SESSION_MAX_AGE", "24h", &config.Session.MaxAge},
+
+		{"CLUSTER_ENABLED", "false", &config.Cluster.Enabled},
+		{"CLUSTER_NODE_ID", "", &config.Cluster.NodeID},
+		{"CLUSTER_LEASE_TTL", "30s", &config.Cluster.LeaseTTL},
+		{"CLUSTER_LEASE_HEARTBEAT", "10s", &config.Cluster.LeaseHeartbeat},
+
+		{"RECONNECT_ATTEMPTS", "5", &config.Reconnect.Attempts},
+		{"RECONNECT_DELAY", "5s", &config.Reconnect.Delay},
+
+		{"RATE_MANAGER_PER_MINUTE", "60", &config.RateLimit.ManagerPerMinute},
+		{"RATE_MANAGER_BURST", "10", &config.RateLimit.ManagerBurst},
+		{"RATE_CHAT_PER_MINUTE", "20", &config.RateLimit.ChatPerMinute},
+		{"RATE_CHAT_BURST", "5", &config.RateLimit.ChatBurst},
+		{"RATE_QUEUE_SIZE", "100", &config.RateLimit.QueueSize},
+
+		{"METRICS_ADDR", "", &config.Metrics.Addr},
+
+		{"DEDUP_WINDOW", "10m", &config.Dedup.Window},
+	}
+}
+
+func defaultConfig() *Config {
+	config := &Config{}
+
+	for _, s := range config.settings() {
+		err := s.set(s.Default)
+
+		if err != nil {
+			panic(fmt.Sprintf("%s: %s", "Invalid default for "+s.Env, err))
+		}
+	}
+
+	return config
+}
+
+func flagName(env string) string {
+	return strings.Replace(strings.ToLower(env), "_", "-", -1)
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Second * time.Duration(seconds), nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+func (s setting) set(value string) error {
+	var err error
+
+	switch target := s.Target.(type) {
+	case *string:
+		*target = value
+	case *int:
+		*target, err = strconv.Atoi(value)
+	case *bool:
+		*target, err = strconv.ParseBool(value)
+	case *time.Duration:
+		*target, err = parseDuration(value)
+	case *[]string:
+		*target = []string{}
+
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported setting type %T", s.Target)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %s", s.Env, err)
+	}
+
+	return nil
+}
+
+func loadConfig(args []string) (*Config, []string, error) {
+	config := defaultConfig()
+	settings := config.settings()
+
+	flags := flag.NewFlagSet("micro-service-jivosite", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "path to env file with settings")
+	values := make(map[string]*string)
+
+	for _, s := range settings {
+		values[s.Env] = flags.String(flagName(s.Env), "", "overrides "+s.Env)
+	}
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fileValues := map[string]string{}
+
+	if *file != "" {
+		fileValues, err = godotenv.Read(*file)
+
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if _, err := os.Stat(".env"); err == nil {
+		fileValues, err = godotenv.Read(".env")
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	visited := map[string]bool{}
+
+	flags.Visit(func(f *flag.Flag) {
+		visited[f.Name] = true
+	})
+
+	for _, s := range settings {
+		if value, ok := fileValues[s.Env]; ok {
+			err = s.set(value)
+		}
+
+		if value, ok := os.LookupEnv(s.Env); ok && err == nil {
+			err = s.set(value)
+		}
+
+		if visited[flagName(s.Env)] && err == nil {
+			err = s.set(*values[s.Env])
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return config, flags.Args(), config.validate()
+}
+
+func (config *Config) validate() error {
+	var problems []string
+
+	required := map[string]string{
+		"RABBITMQ_ERP_HOST":    config.AMQP.Host,
+		"RABBITMQ_ERP_LOGIN":   config.AMQP.Login,
+		"MYSQL_DATABASE_HOST":  config.MySQL.Host,
+		"MYSQL_DATABASE_USER":  config.MySQL.User,
+		"MYSQL_DATABASE_DB":    config.MySQL.DB,
+		"LOGTOEMAIL_SMTP_HOST": config.SMTP.Host,
+		"LOGTOEMAIL_SMTP_FROM": config.SMTP.From,
+		"LOGTOEMAIL_SMTP_TO":   config.SMTP.To,
+		"JIVOSITE_API_URL":     config.JivoSite.ApiURL,
+		"QUEUE_MANAGER_STATUS": config.Queues.ManagerStatus,
+	}
+
+	if config.Exchange.Name == "" {
+		required["QUEUE_ERP_MESSAGES"] = config.Queues.ErpMessages
+	} else {
+		required["EXCHANGE_ROUTING_PREFIX"] = config.Exchange.Prefix
+	}
+
+	for _, s := range config.settings() {
+		if value, ok := required[s.Env]; ok && value == "" {
+			problems = append(problems, s.Env+" is required")
+		}
+	}
+
+	positive := map[string]int{
+		"RABBITMQ_ERP_PORT":        config.AMQP.Port,
+		"MYSQL_DATABASE_PORT":      config.MySQL.Port,
+		"LOGTOEMAIL_SMTP_PORT":     config.SMTP.Port,
+		"JIVOSITE_SITE_ID":         config.JivoSite.SiteID,
+		"LOG_HEARTBEAT_SAMPLE":     config.Log.HeartbeatSample,
+		"ATTACHMENT_MAX_FILE_SIZE": config.Attachment.MaxFileSize,
+		"ATTACHMENT_MAX_PIXELS":    config.Attachment.MaxPixels,
+		"TIMEOUT_HTTP":             int(config.Timeouts.HTTP),
+		"TIMEOUT_PING":             int(config.Timeouts.Ping),
+		"TIMEOUT_MIRROR":           int(config.Timeouts.Mirror),
+		"ALERT_KEY_INTERVAL":       int(config.Alert.KeyInterval),
+		"ALERT_DIGEST_INTERVAL":    int(config.Alert.Digest),
+		"ALERT_RESOLVE_AFTER":      int(config.Alert.ResolveAfter),
+		"ALERT_THRESHOLD":          config.Alert.Threshold,
+		"SESSION_MAX_AGE":          int(config.Session.MaxAge),
+		"CLUSTER_LEASE_TTL":        int(config.Cluster.LeaseTTL),
+		"CLUSTER_LEASE_HEARTBEAT":  int(config.Cluster.LeaseHeartbeat),
+		"RECONNECT_DELAY":          int(config.Reconnect.Delay),
+		"RATE_QUEUE_SIZE":          config.RateLimit.QueueSize,
+		"COMMAND_WORKERS":          config.Commands.Workers,
+		"COMMAND_PREFETCH":         config.Commands.Prefetch,
+		"CHATS_RETENTION":          int(config.Chats.Retention),
+		"CHATS_HISTORY_LIMIT":      config.Chats.HistoryLimit,
+		"MEDIA_MIRROR_WORKERS":     config.Media.Workers,
+		"MEDIA_MIRROR_QUEUE":       config.Media.Queue,
+		"AUTO_REPLY_REFRESH":       int(config.AutoReply.Refresh),
+	}
+
+	for _, s := range config.settings() {
+		if value, ok := positive[s.Env]; ok && value <= 0 {
+			problems = append(problems, s.Env+" must be positive")
+		}
+	}
+
+	if _, err := logrus.ParseLevel(config.Log.Level); err != nil {
+		problems = append(problems, "LOG_LEVEL is invalid")
+	}
+
+	notNegative := map[string]int{
+		"RECONNECT_ATTEMPTS":      config.Reconnect.Attempts,
+		"RATE_MANAGER_PER_MINUTE": config.RateLimit.ManagerPerMinute,
+		"RATE_MANAGER_BURST":      config.RateLimit.ManagerBurst,
+		"RATE_CHAT_PER_MINUTE":    config.RateLimit.ChatPerMinute,
+		"RATE_CHAT_BURST":         config.RateLimit.ChatBurst,
+		"DEDUP_WINDOW":            int(config.Dedup.Window),
+		"AUTO_ACCEPT_MAX_CHATS":   config.AutoAccept.MaxChats,
+		"AUTO_REPLY_COOLDOWN":     int(config.AutoReply.Cooldown),
+	}
+
+	for _, s := range config.settings() {
+		if value, ok := notNegative[s.Env]; ok && value < 0 {
+			problems = append(problems, s.Env+" must not be negative")
+		}
+	}
+
+	if strings.ContainsAny(config.Exchange.Prefix, "*# ") {
+		problems = append(problems, "EXCHANGE_ROUTING_PREFIX must not contain wildcards or spaces")
+	}
+
+	if config.Log.Format != "text" && config.Log.Format != "json" {
+		problems = append(problems, "LOG_FORMAT must be text or json")
+	}
+
+	switch config.Media.Storage {
+	case "":
+	case "fs":
+		if config.Media.Path == "" {
+			problems = append(problems, "MEDIA_STORAGE_PATH is required for fs media storage")
+		}
+	case "s3":
+		if config.Media.S3Bucket == "" {
+			problems = append(problems, "MEDIA_S3_BUCKET is required for s3 media storage")
+		}
+	default:
+		problems = append(problems, "MEDIA_STORAGE must be empty, fs or s3")
+	}
+
+	if config.Cluster.Enabled && config.Startup.SetOffline {
+		problems = append(problems, "STARTUP_SET_OFFLINE must be false when CLUSTER_ENABLED is set")
+	}
+
+	if config.Cluster.LeaseHeartbeat >= config.Cluster.LeaseTTL {
+		problems = append(problems, "CLUSTER_LEASE_HEARTBEAT must be shorter than CLUSTER_LEASE_TTL")
+	}
+
+	if _, err := time.LoadLocation(config.AutoAccept.Timezone); err != nil {
+		problems = append(problems, "AUTO_ACCEPT_TIMEZONE is invalid")
+	}
+
+	if _, err := time.LoadLocation(config.AutoReply.Timezone); err != nil {
+		problems = append(problems, "AUTO_REPLY_TIMEZONE is invalid")
+	}
+
+	if err := (Schedule{Hours: config.AutoAccept.Hours, Days: config.AutoAccept.Days}).validate(); err != nil {
+		problems = append(problems, "AUTO_ACCEPT_HOURS or AUTO_ACCEPT_DAYS is invalid: "+err.Error())
+	}
+
+	keyring, err := parseKeyring(config.Credentials.Keys)
+
+	if err != nil {
+		problems = append(problems, "CREDENTIALS_KEYS is invalid: "+err.Error())
+	} else if config.Credentials.RequireEncrypted && keyring.primary() == nil {
+		problems = append(problems, "CREDENTIALS_KEYS is required when CREDENTIALS_REQUIRE_ENCRYPTED is set")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigValidateAutoAcceptSchedule(t *testing.T) {
+	tests := []struct {
+		hours string
+		days  []string
+		valid bool
+	}{
+		{"", nil, true},
+		{"09:00-18:00", []string{"mon", "fri"}, true},
+		{"bogus", nil, false},
+		{"09:00-18:00", []string{"someday"}, false},
+	}
+
+	for _, test := range tests {
+		candidate := Config{}
+		candidate.AutoAccept.Hours = test.hours
+		candidate.AutoAccept.Days = test.days
+
+		err := candidate.validate()
+		invalid := err != nil && strings.Contains(err.Error(), "AUTO_ACCEPT_HOURS or AUTO_ACCEPT_DAYS is invalid")
+
+		if invalid == test.valid {
+			t.Errorf("%q %v: got %v, want valid %v", test.hours, test.days, err, test.valid)
+		}
+	}
+}
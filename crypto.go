@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const secretPrefix = "enc:v1:"
+
+type SecretKey struct {
+	ID   string
+	aead cipher.AEAD
+}
+
+type SecretKeyring struct {
+	Keys []*SecretKey
+}
+
+func parseKeyring(entries []string) (*SecretKeyring, error) {
+	keyring := &SecretKeyring{}
+	seen := map[string]bool{}
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("key must look like <id>:<base64 key>")
+		}
+
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("duplicate key id %q", parts[0])
+		}
+
+		seen[parts[0]] = true
+
+		raw, err := base64.StdEncoding.DecodeString(parts[1])
+
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %s", parts[0], err)
+		}
+
+		block, err := aes.NewCipher(raw)
+
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %s", parts[0], err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %s", parts[0], err)
+		}
+
+		keyring.Keys = append(keyring.Keys, &SecretKey{parts[0], aead})
+	}
+
+	return keyring, nil
+}
+
+func (keyring *SecretKeyring) primary() *SecretKey {
+	if keyring == nil || len(keyring.Keys) == 0 {
+		return nil
+	}
+
+	return keyring.Keys[0]
+}
+
+func (keyring *SecretKeyring) key(id string) *SecretKey {
+	for _, key := range keyring.Keys {
+		if key.ID == id {
+			return key
+		}
+	}
+
+	return nil
+}
+
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+func encryptedWith(value string) string {
+	if !isEncrypted(value) {
+		return ""
+	}
+
+	return strings.SplitN(strings.TrimPrefix(value, secretPrefix), ":", 2)[0]
+}
+
+func (keyring *SecretKeyring) encrypt(plain string) (string, error) {
+	key := keyring.primary()
+
+	if key == nil {
+		return "", errors.New("no credentials key configured")
+	}
+
+	nonce := make([]byte, key.aead.NonceSize())
+
+	_, err := io.ReadFull(rand.Reader, nonce)
+
+	if err != nil {
+		return "", err
+	}
+
+	sealed := key.aead.Seal(nonce, nonce, []byte(plain), []byte(key.ID))
+
+	return secretPrefix + key.ID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (keyring *SecretKeyring) decrypt(value string) (string, error) {
+	if !isEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, secretPrefix), ":", 2)
+
+	if len(parts) != 2 {
+		return "", errors.New("malformed encrypted credential")
+	}
+
+	key := keyring.key(parts[0])
+
+	if key == nil {
+		return "", fmt.Errorf("unknown credentials key %q", parts[0])
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < key.aead.NonceSize() {
+		return "", errors.New("malformed encrypted credential")
+	}
+
+	plain, err := key.aead.Open(nil, sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():], []byte(key.ID))
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+func encryptCredentials() (int, error) {
+	keyring, err := parseKeyring(config.Credentials.Keys)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if keyring.primary() == nil {
+		return 0, errors.New("CREDENTIALS_KEYS is not configured")
+	}
+
+	passwords, err := getPasswords()
+
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+
+	for id, password := range passwords {
+		if encryptedWith(password) == keyring.primary().ID {
+			continue
+		}
+
+		plain, err := keyring.decrypt(password)
+
+		if err != nil {
+			return updated, fmt.Errorf("manager %s: %s", id, err)
+		}
+
+		encrypted, err := keyring.encrypt(plain)
+
+		if err != nil {
+			return updated, err
+		}
+
+		err = setPassword(id, encrypted)
+
+		if err != nil {
+			return updated, fmt.Errorf("manager %s: %s", id, err)
+		}
+
+		updated = updated + 1
+	}
+
+	return updated, nil
+}
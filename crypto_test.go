@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(id string, fill byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(fill), 32)))
+}
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		keys    int
+		valid   bool
+	}{
+		{"empty", nil, 0, true},
+		{"rotation", []string{testKey("k2", 'b'), testKey("k1", 'a')}, 2, true},
+		{"missing id", []string{":" + base64.StdEncoding.EncodeToString(make([]byte, 32))}, 0, false},
+		{"missing separator", []string{"k1"}, 0, false},
+		{"duplicate id", []string{testKey("k1", 'a'), testKey("k1", 'b')}, 0, false},
+		{"bad base64", []string{"k1:not base64"}, 0, false},
+		{"bad key size", []string{"k1:" + base64.StdEncoding.EncodeToString(make([]byte, 7))}, 0, false},
+	}
+
+	for _, test := range tests {
+		keyring, err := parseKeyring(test.entries)
+
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %v", test.name, err, test.valid)
+			continue
+		}
+
+		if err == nil && len(keyring.Keys) != test.keys {
+			t.Errorf("%s: got %d keys, want %d", test.name, len(keyring.Keys), test.keys)
+		}
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old, err := parseKeyring([]string{testKey("k1", 'a')})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := parseKeyring([]string{testKey("k2", 'b'), testKey("k1", 'a')})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foreign, err := parseKeyring([]string{testKey("k1", 'c')})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := old.encrypt("secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resealed, err := rotated.encrypt("secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keyring *SecretKeyring
+		value   string
+		want    string
+		valid   bool
+	}{
+		{"plain text passes through", old, "secret", "secret", true},
+		{"same key", old, sealed, "secret", true},
+		{"old key after rotation", rotated, sealed, "secret", true},
+		{"new key", rotated, resealed, "secret", true},
+		{"unknown key id", old, resealed, "", false},
+		{"same id, other key", foreign, sealed, "", false},
+		{"malformed", old, secretPrefix + "k1", "", false},
+		{"truncated", old, secretPrefix + "k1:AAAA", "", false},
+	}
+
+	for _, test := range tests {
+		got, err := test.keyring.decrypt(test.value)
+
+		if (err == nil) != test.valid || got != test.want {
+			t.Errorf("%s: got %q, %v", test.name, got, err)
+		}
+	}
+
+	if encryptedWith(sealed) != "k1" || encryptedWith(resealed) != "k2" || encryptedWith("secret") != "" {
+		t.Errorf("encryptedWith: got %q, %q", encryptedWith(sealed), encryptedWith(resealed))
+	}
+
+	if again, _ := old.encrypt("secret"); again == sealed {
+		t.Errorf("encrypt reused a nonce")
+	}
+
+	if _, err := (&SecretKeyring{}).encrypt("secret"); err == nil {
+		t.Errorf("encrypt without keys: got no error")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+type SentMessage struct {
+	Result  *CommandResultParams
+	Waiting int
+	SentAt  time.Time
+}
+
+type DedupStore struct {
+	entries  map[string]*SentMessage
+	prunedAt time.Time
+	mu       sync.Mutex
+}
+
+var dedupStore = &DedupStore{entries: make(map[string]*SentMessage)}
+
+func dedupKey(chatId int, privateId string) string {
+	return fmt.Sprintf("%d:%s", chatId, privateId)
+}
+
+func (store *DedupStore) prune(now time.Time) {
+	if now.Sub(store.prunedAt) < time.Minute {
+		return
+	}
+
+	store.prunedAt = now
+
+	for key, sent := range store.entries {
+		if now.Sub(sent.SentAt) > config.Dedup.Window {
+			delete(store.entries, key)
+		}
+	}
+
+	if MySQL == nil {
+		return
+	}
+
+	err := deleteSentMessages(config.Dedup.Window)
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Can`t prune sent messages:")
+	}
+}
+
+func (store *DedupStore) claim(managerId string, command string, chatId int, privateId string) (*CommandResultParams, bool) {
+	if privateId == "" || config.Dedup.Window <= 0 {
+		return nil, false
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	store.prune(now)
+
+	key := dedupKey(chatId, privateId)
+
+	if sent, ok := store.entries[key]; ok && now.Sub(sent.SentAt) <= config.Dedup.Window {
+		if sent.Result == nil {
+			sent.Waiting = sent.Waiting + 1
+		}
+
+		return sent.Result, true
+	}
+
+	if MySQL != nil {
+		found, result, err := getSentMessage(chatId, privateId, config.Dedup.Window)
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"manager":    managerId,
+				"chat_id":    chatId,
+				"private_id": privateId,
+				"err":        err,
+			}).Error("Can`t get sent message:")
+		}
+
+		if found {
+			if result == nil {
+				result = &CommandResultParams{
+					Name:      "command_result",
+					ManagerID: managerId,
+					Command:   command,
+					ChatID:    chatId,
+					PrivateID: privateId,
+					Ok:        true,
+				}
+			}
+
+			store.entries[key] = &SentMessage{Result: result, SentAt: now}
+
+			return result, true
+		}
+
+		err = insertSentMessage(managerId, command, chatId, privateId)
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"manager":    managerId,
+				"chat_id":    chatId,
+				"private_id": privateId,
+				"err":        err,
+			}).Error("Can`t store sent message:")
+		}
+	}
+
+	store.entries[key] = &SentMessage{SentAt: now}
+
+	return nil, false
+}
+
+func (store *DedupStore) complete(result CommandResultParams) {
+	if result.PrivateID == "" || config.Dedup.Window <= 0 {
+		return
+	}
+
+	store.mu.Lock()
+	waiting := 0
+	key := dedupKey(result.ChatID, result.PrivateID)
+
+	if sent, ok := store.entries[key]; ok {
+		waiting = sent.Waiting
+		sent.Waiting = 0
+		sent.Result = &result
+	}
+	store.mu.Unlock()
+
+	if MySQL != nil {
+		err := setSentMessageResult(result)
+
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"manager":    result.ManagerID,
+				"chat_id":    result.ChatID,
+				"private_id": result.PrivateID,
+				"err":        err,
+			}).Error("Can`t store sent message result:")
+		}
+	}
+
+	for i := 0; i < waiting; i++ {
+		publishDuplicate(result)
+	}
+}
+
+func (store *DedupStore) forget(chatId int, privateId string) {
+	if privateId == "" || config.Dedup.Window <= 0 {
+		return
+	}
+
+	store.mu.Lock()
+	delete(store.entries, dedupKey(chatId, privateId))
+	store.mu.Unlock()
+
+	if MySQL == nil {
+		return
+	}
+
+	err := deleteSentMessage(chatId, privateId)
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"chat_id":    chatId,
+			"private_id": privateId,
+			"err":        err,
+		}).Error("Can`t delete sent message:")
+	}
+}
+
+func publishDuplicate(result CommandResultParams) {
+	result.Duplicate = true
+
+	err := publishServiceEvent(result)
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"manager": result.ManagerID,
+			"command": result.Command,
+			"error":   err,
+		}).Error("Failed to publish:")
+	}
+}
+
+func (manager *Manager) duplicate(command string, chatId int, privateId string) bool {
+	result, duplicate := dedupStore.claim(manager.Id, command, chatId, privateId)
+
+	if !duplicate {
+		return false
+	}
+
+	countMetric("outgoing_duplicates", 1)
+
+	manager.log().WithFields(logrus.Fields{
+		"command":    command,
+		"chat_id":    chatId,
+		"private_id": privateId,
+	}).Info("Duplicate message skipped:")
+
+	if result != nil {
+		publishDuplicate(*result)
+	}
+
+	return true
+}
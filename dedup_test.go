@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDedupStoreLifecycle(t *testing.T) {
+	config.Dedup.Window = 10 * time.Minute
+
+	published := []CommandResultParams{}
+	publish := publishToErp
+
+	defer func() {
+		publishToErp = publish
+	}()
+
+	publishToErp = func(envelope *Envelope) error {
+		event := struct {
+			Params CommandResultParams `json:"params"`
+		}{}
+
+		err := json.Unmarshal(envelope.Payload, &event)
+		published = append(published, event.Params)
+
+		return err
+	}
+
+	store := &DedupStore{entries: make(map[string]*SentMessage)}
+	result := CommandResultParams{Name: "command_result", ManagerID: "42", Command: "agent_message", ChatID: 1, PrivateID: "p1", Ok: true}
+
+	steps := []struct {
+		name      string
+		action    string
+		chatId    int
+		privateId string
+		duplicate bool
+		result    bool
+		published int
+	}{
+		{"first send is claimed", "claim", 1, "p1", false, false, 0},
+		{"retry while in flight waits", "claim", 1, "p1", true, false, 0},
+		{"same id in another chat is new", "claim", 2, "p1", false, false, 0},
+		{"without private_id nothing is tracked", "claim", 1, "", false, false, 0},
+		{"completion answers the waiting retry", "complete", 1, "p1", false, false, 1},
+		{"retry after completion gets the result", "claim", 1, "p1", true, true, 1},
+		{"forgotten id can be sent again", "forget", 1, "p1", false, false, 1},
+		{"claim after forget", "claim", 1, "p1", false, false, 1},
+	}
+
+	for _, step := range steps {
+		switch step.action {
+		case "claim":
+			got, duplicate := store.claim("42", "agent_message", step.chatId, step.privateId)
+
+			if duplicate != step.duplicate || (got != nil) != step.result {
+				t.Errorf("%s: got %v, %v", step.name, got, duplicate)
+			}
+		case "complete":
+			store.complete(result)
+		case "forget":
+			store.forget(step.chatId, step.privateId)
+		}
+
+		if len(published) != step.published {
+			t.Errorf("%s: published %d results, want %d", step.name, len(published), step.published)
+		}
+	}
+
+	if len(published) > 0 && (!published[0].Duplicate || published[0].PrivateID != "p1") {
+		t.Errorf("published %+v, want a duplicate of p1", published[0])
+	}
+}
+
+func TestDedupStoreDisabled(t *testing.T) {
+	config.Dedup.Window = 0
+
+	store := &DedupStore{entries: make(map[string]*SentMessage)}
+
+	for i := 0; i < 2; i++ {
+		if _, duplicate := store.claim("42", "agent_message", 1, "p1"); duplicate {
+			t.Errorf("claim %d: duplicate with DEDUP_WINDOW=0", i)
+		}
+	}
+}
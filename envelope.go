@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/streadway/amqp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const EnvelopeSchemaVersion = 1
+
+type Envelope struct {
+	SchemaVersion int             `json:"schema_version"`
+	Type          string          `json:"type"`
+	ManagerID     string          `json:"manager_id"`
+	SiteID        int             `json:"site_id"`
+	ReceivedAt    time.Time       `json:"received_at"`
+	Sequence      int64           `json:"sequence"`
+	CorrelationID string          `json:"correlation_id"`
+	Backfilled    bool            `json:"backfilled,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+type EventMeta struct {
+	Name      string `json:"name"`
+	ManagerID string `json:"manager_id"`
+	PrivateID string `json:"private_id"`
+}
+
+var sequences = struct {
+	values map[string]int64
+	mu     sync.Mutex
+}{values: make(map[string]int64)}
+
+func nextSequence(managerId string) int64 {
+	sequences.mu.Lock()
+	defer sequences.mu.Unlock()
+
+	sequences.values[managerId] = sequences.values[managerId] + 1
+
+	return sequences.values[managerId]
+}
+
+func newEnvelope(eventType string, managerId string, correlationId string, receivedAt time.Time, payload []byte) *Envelope {
+	if correlationId == "" {
+		correlationId = newUUID()
+	}
+
+	return &Envelope{
+		SchemaVersion: EnvelopeSchemaVersion,
+		Type:          eventType,
+		ManagerID:     managerId,
+		SiteID:        config.JivoSite.SiteID,
+		ReceivedAt:    receivedAt,
+		Sequence:      nextSequence(managerId),
+		CorrelationID: correlationId,
+		Payload:       payload,
+	}
+}
+
+func (envelope *Envelope) headers() amqp.Table {
+	return amqp.Table{
+		"schema_version": int32(envelope.SchemaVersion),
+		"type":           envelope.Type,
+		"manager_id":     envelope.ManagerID,
+		"site_id":        int32(envelope.SiteID),
+		"received_at":    envelope.ReceivedAt,
+		"sequence":       envelope.Sequence,
+		"correlation_id": envelope.CorrelationID,
+		"backfilled":     envelope.Backfilled,
+		"routing_key":    envelope.routingKey(),
+	}
+}
+
+func routingWord(value string) string {
+	value = strings.NewReplacer(".", "_", "*", "_", "#", "_", " ", "_").Replace(value)
+
+	if value == "" {
+		return "none"
+	}
+
+	return value
+}
+
+func (envelope *Envelope) routingKey() string {
+	event := strings.TrimPrefix(envelope.Type, "jivosite.")
+
+	return fmt.Sprintf("%s.%d.%s.%s", config.Exchange.Prefix, envelope.SiteID, routingWord(envelope.ManagerID), event)
+}
+
+func frameEventType(name string) string {
+	if name == "" {
+		return "jivosite.unknown"
+	}
+
+	return "jivosite." + name
+}
+
+func batchItemName(element []interface{}) string {
+	if len(element) == 0 {
+		return ""
+	}
+
+	name, _ := element[0].(string)
+
+	return name
+}
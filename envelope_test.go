@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestEnvelopeRoutingKey(t *testing.T) {
+	config.Exchange.Prefix = "jivosite"
+
+	tests := []struct {
+		eventType string
+		managerId string
+		siteId    int
+		want      string
+	}{
+		{frameEventType("client_message"), "42", 839750, "jivosite.839750.42.client_message"},
+		{frameEventType(""), "42", 839750, "jivosite.839750.42.unknown"},
+		{"service.manager_status", "42", 839750, "jivosite.839750.42.service.manager_status"},
+		{"echo.agent_message", "42", 839750, "jivosite.839750.42.echo.agent_message"},
+		{"mirror.client_message", "42", 839750, "jivosite.839750.42.mirror.client_message"},
+		{"service.command_error", "", 839750, "jivosite.839750.none.service.command_error"},
+		{"service.manager_status", "a.b*#c d", 1, "jivosite.1.a_b__c_d.service.manager_status"},
+	}
+
+	for _, test := range tests {
+		envelope := Envelope{Type: test.eventType, ManagerID: test.managerId, SiteID: test.siteId}
+
+		if got := envelope.routingKey(); got != test.want {
+			t.Errorf("%s for %q: got %q, want %q", test.eventType, test.managerId, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+type ChatHistoryCommand struct {
+	ManagerID string `json:"managerId"`
+	Params    struct {
+		Name      string `json:"name"`
+		ChatID    int    `json:"chat_id"`
+		Limit     int    `json:"limit"`
+		PrivateID string `json:"private_id"`
+	} `json:"params"`
+}
+
+type HistoryMessage struct {
+	MessageID string      `json:"message_id"`
+	ChatID    int         `json:"chat_id"`
+	Author    string      `json:"author"`
+	AgentID   int         `json:"agent_id,omitempty"`
+	ClientID  int         `json:"client_id,omitempty"`
+	Message   string      `json:"message"`
+	Media     interface{} `json:"media,omitempty"`
+	SentAt    *time.Time  `json:"sent_at,omitempty"`
+}
+
+type ChatHistoryEventParams struct {
+	Name      string           `json:"name"`
+	ManagerID string           `json:"manager_id"`
+	ChatID    int              `json:"chat_id"`
+	PrivateID string           `json:"private_id"`
+	Messages  []HistoryMessage `json:"messages"`
+}
+
+func stringParam(params map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch value := params[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case float64:
+			return strconv.FormatInt(int64(value), 10)
+		}
+	}
+
+	return ""
+}
+
+func timeParam(params map[string]interface{}, keys ...string) *time.Time {
+	for _, key := range keys {
+		var at time.Time
+
+		switch value := params[key].(type) {
+		case float64:
+			if value > 1e12 {
+				at = time.Unix(0, int64(value)*int64(time.Millisecond))
+			} else {
+				at = time.Unix(int64(value), 0)
+			}
+		case string:
+			if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+				at = time.Unix(seconds, 0)
+			} else if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+				at = parsed
+			} else {
+				continue
+			}
+		default:
+			continue
+		}
+
+		at = at.UTC()
+
+		return &at
+	}
+
+	return nil
+}
+
+func historyAuthor(params map[string]interface{}) string {
+	switch author := stringParam(params, "from", "sender_type", "author", "type"); author {
+	case "client", "visitor":
+		return "client"
+	case "agent", "operator":
+		return "agent"
+	case "system":
+		return "system"
+	}
+
+	if intParam(params, "agent_id") > 0 {
+		return "agent"
+	}
+
+	if intParam(params, "client_id") > 0 {
+		return "client"
+	}
+
+	return "system"
+}
+
+func normalizeHistoryMessage(chatId int, params map[string]interface{}) HistoryMessage {
+	return HistoryMessage{
+		MessageID: stringParam(params, "message_id", "id", "private_id"),
+		ChatID:    chatId,
+		Author:    historyAuthor(params),
+		AgentID:   intParam(params, "agent_id"),
+		ClientID:  intParam(params, "client_id"),
+		Message:   stringParam(params, "message", "text"),
+		Media:     params["media"],
+		SentAt:    timeParam(params, "created_at", "timestamp", "ts", "date"),
+	}
+}
+
+func (manager *Manager) fetchChatHistory(chatId int, limit int) (*ChatHistoryResponse, error) {
+	history, err := getChatHistory(manager, chatId, limit)
+
+	if err != errUnauthorized {
+		return history, err
+	}
+
+	response, err := refreshApiKey(manager)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manager.SuccessLoginResponse = response
+
+	err = manager.saveSession()
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Manager can`t save session:")
+	}
+
+	return getChatHistory(manager, chatId, limit)
+}
+
+func (manager *Manager) chatHistoryCommand(command []byte) error {
+	chatHistoryCommand := ChatHistoryCommand{}
+
+	err := json.Unmarshal(command, &chatHistoryCommand)
+
+	if err != nil {
+		return err
+	}
+
+	params := chatHistoryCommand.Params
+	limit := params.Limit
+
+	if limit <= 0 || limit > config.Chats.HistoryLimit {
+		limit = config.Chats.HistoryLimit
+	}
+
+	var history *ChatHistoryResponse
+
+	if params.ChatID <= 0 {
+		err = errors.New("chat_id is required")
+	} else {
+		history, err = manager.fetchChatHistory(params.ChatID, limit)
+	}
+
+	if err != nil {
+		countMetric("history_failed", 1)
+
+		publishErr := publishCommandError(CommandErrorParams{
+			ManagerID: manager.Id,
+			Command:   params.Name,
+			ChatID:    params.ChatID,
+			PrivateID: params.PrivateID,
+			Error:     err.Error(),
+		})
+
+		if publishErr != nil {
+			manager.log().WithFields(logrus.Fields{
+				"command": params.Name,
+				"error":   publishErr,
+			}).Error("Failed to publish:")
+		}
+
+		return err
+	}
+
+	messages := []HistoryMessage{}
+
+	for _, message := range history.Messages {
+		normalized := normalizeHistoryMessage(params.ChatID, message)
+
+		if mediaStorage != nil && normalized.Author != "agent" {
+			mirrorMediaValue(manager, message)
+			normalized.Media = message["media"]
+		}
+
+		messages = append(messages, normalized)
+	}
+
+	countMetric("history_fetched", 1)
+
+	manager.log().WithFields(logrus.Fields{
+		"chat_id":  params.ChatID,
+		"messages": len(messages),
+	}).Info("Manager fetched chat history:")
+
+	return publishServiceEvent(ChatHistoryEventParams{
+		Name:      "chat_history",
+		ManagerID: manager.Id,
+		ChatID:    params.ChatID,
+		PrivateID: params.PrivateID,
+		Messages:  messages,
+	})
+}
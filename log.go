@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"os"
+)
+
+type LogLevelCommand struct {
+	ManagerID string `json:"managerId"`
+	Params    struct {
+		Name  string `json:"name"`
+		Level string `json:"level"`
+	} `json:"params"`
+}
+
+type FieldsHook struct {
+	Fields logrus.Fields
+}
+
+var heartbeatSample = 30
+
+func (hook *FieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (hook *FieldsHook) Fire(entry *logrus.Entry) error {
+	for key, value := range hook.Fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+
+	return nil
+}
+
+func configureLogger() error {
+	level, err := logrus.ParseLevel(config.Log.Level)
+
+	if err != nil {
+		return err
+	}
+
+	logger.SetLevel(level)
+	logger.SetOutput(os.Stdout)
+
+	if config.Log.Format == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logger.SetFormatter(&logrus.TextFormatter{})
+	}
+
+	heartbeatSample = config.Log.HeartbeatSample
+
+	if !config.Log.Verbose {
+		logger.AddHook(&RedactHook{})
+	}
+
+	logger.AddHook(&FieldsHook{logrus.Fields{
+		"app":  config.SMTP.AppName,
+		"site": config.JivoSite.SiteID,
+	}})
+
+	if config.Log.Verbose {
+		logger.Warn("Verbose logging enabled, secrets and personal data are not redacted:")
+	}
+
+	return nil
+}
+
+func (manager *Manager) log() *logrus.Entry {
+	l := logger
+
+	if v, ok := manager.logger.Load().(*logrus.Logger); ok {
+		l = v
+	}
+
+	return l.WithField("manager", manager.Id)
+}
+
+func (manager *Manager) setLogLevel(level logrus.Level) {
+	l := logrus.New()
+	l.Out = logger.Out
+	l.Formatter = logger.Formatter
+	l.Hooks = logger.Hooks
+	l.SetLevel(level)
+
+	manager.logger.Store(l)
+}
+
+func (manager *Manager) heartbeat() *logrus.Entry {
+	manager.mu.Lock()
+	manager.heartbeats = manager.heartbeats + 1
+	sampled := manager.heartbeats%heartbeatSample == 1 || heartbeatSample == 1
+	manager.mu.Unlock()
+
+	if !sampled {
+		return nil
+	}
+
+	return manager.log().WithField("sample", heartbeatSample)
+}
+
+func (manager *Manager) logLevelCommand(command []byte) error {
+	logLevelCommand := LogLevelCommand{}
+
+	err := json.Unmarshal(command, &logLevelCommand)
+
+	if err != nil {
+		return err
+	}
+
+	level, err := logrus.ParseLevel(logLevelCommand.Params.Level)
+
+	if err != nil {
+		return err
+	}
+
+	manager.setLogLevel(level)
+
+	manager.log().WithFields(logrus.Fields{
+		"command": logLevelCommand.Params.Name,
+		"level":   level.String(),
+	}).Info("Manager log level changed:")
+
+	return nil
+}
@@ -3,13 +3,10 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
-	"github.com/zbindenren/logrus_mail"
 	"os"
 	"os/signal"
-	"strconv"
 )
 
 var AMQPConnection *amqp.Connection
@@ -27,105 +24,127 @@ func failOnError(err error, msg string) {
 	}
 }
 
-func getenvInt(key string) (int, error) {
-	s := os.Getenv(key)
-	v, err := strconv.Atoi(s)
+func connectAMQP() error {
+	cs := fmt.Sprintf("amqp://%s:%s@%s:%d/%s",
+		config.AMQP.Login,
+		config.AMQP.Pass,
+		config.AMQP.Host,
+		config.AMQP.Port,
+		config.AMQP.VHost)
+
+	connection, err := amqp.Dial(cs)
 
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("%s: %s", "Failed to connect to RabbitMQ", err)
 	}
 
-	return v, nil
-}
+	AMQPConnection = connection
 
-func init() {
-	err := godotenv.Load()
+	channel, err := AMQPConnection.Channel()
 
 	if err != nil {
-		panic(fmt.Sprintf("%s: %s", "Error loading .env file", err))
+		return fmt.Errorf("%s: %s", "Failed to open a channel", err)
 	}
 
-	port, err := getenvInt("LOGTOEMAIL_SMTP_PORT")
+	AMQPChannel = channel
+
+	return nil
+}
+
+func connectMySQL() error {
+	db, err := sql.Open("mysql", fmt.Sprintf(
+		"%s:%s@tcp(%s:%d)/%s?parseTime=true",
+		config.MySQL.User,
+		config.MySQL.Password,
+		config.MySQL.Host,
+		config.MySQL.Port,
+		config.MySQL.DB,
+	))
+
+	if err == nil {
+		err = db.Ping()
+	}
 
 	if err != nil {
-		panic(fmt.Sprintf("%s: %s", "Error read smtp port from env", err))
+		return fmt.Errorf("%s: %s", "Failed to connect MySQL", err)
 	}
 
-	hook, err := logrus_mail.NewMailAuthHook(
-		os.Getenv("LOGTOEMAIL_APP_NAME"),
-		os.Getenv("LOGTOEMAIL_SMTP_HOST"),
-		port,
-		os.Getenv("LOGTOEMAIL_SMTP_FROM"),
-		os.Getenv("LOGTOEMAIL_SMTP_TO"),
-		os.Getenv("LOGTOEMAIL_SMTP_USERNAME"),
-		os.Getenv("LOGTOEMAIL_SMTP_PASSWORD"),
-	)
+	MySQL = db
 
-	logger.SetLevel(logrus.DebugLevel)
-	logger.SetOutput(os.Stdout)
-	logger.SetFormatter(&logrus.TextFormatter{})
+	return nil
+}
 
-	logger.Hooks.Add(hook)
+func setup() error {
+	err := configureLogger()
 
 	if err != nil {
-		panic(fmt.Sprintf("%s: %s", "Error add hook to send logs to email", err))
+		return err
 	}
 
-	cs := fmt.Sprintf("amqp://%s:%s@%s:%s/%s",
-		os.Getenv("RABBITMQ_ERP_LOGIN"),
-		os.Getenv("RABBITMQ_ERP_PASS"),
-		os.Getenv("RABBITMQ_ERP_HOST"),
-		os.Getenv("RABBITMQ_ERP_PORT"),
-		os.Getenv("RABBITMQ_ERP_VHOST"))
+	hook := newAlertHook()
+	logger.Hooks.Add(hook)
+	go hook.run()
 
-	connection, err := amqp.Dial(cs)
-	failOnError(err, "Failed to connect to RabbitMQ")
-	AMQPConnection = connection
+	attachmentPolicy = loadAttachmentPolicy()
+	mirrorClient.Timeout = config.Timeouts.Mirror
 
-	channel, err := AMQPConnection.Channel()
+	mediaStorage, err = newMediaStorage()
 
-	failOnError(err, "Failed to open a channel")
-	AMQPChannel = channel
+	if err != nil {
+		return fmt.Errorf("%s: %s", "Failed to init media storage", err)
+	}
 
-	failOnError(err, "Failed to declare a queue")
+	return nil
+}
 
-	db, err := sql.Open("mysql", fmt.Sprintf(
-		"%s:%s@tcp(%s:%s)/%s",
-		os.Getenv("MYSQL_DATABASE_USER"),
-		os.Getenv("MYSQL_DATABASE_PASSWORD"),
-		os.Getenv("MYSQL_DATABASE_HOST"),
-		os.Getenv("MYSQL_DATABASE_PORT"),
-		os.Getenv("MYSQL_DATABASE_DB"),
-	))
+func serve() {
+	err := setup()
+	failOnError(err, "Failed to setup service")
 
-	MySQL = db
+	startMirrorWorkers()
 
-	failOnError(err, "Failed to connect MySQL")
+	err = connectMySQL()
+	failOnError(err, "Failed to connect")
 
-	logger.WithFields(logrus.Fields{}).Info("Server init:")
-}
+	err = connectAMQP()
+	failOnError(err, "Failed to connect")
+
+	err = declareExchange()
+	failOnError(err, "Failed to declare an exchange")
 
-func main() {
 	logger.WithFields(logrus.Fields{}).Info("Server starting:")
 
-	err := selOfflineAll()
+	if config.Startup.SetOffline {
+		err = selOfflineAll()
 
-	if err != nil {
-		logger.WithFields(logrus.Fields{
-			"err": err,
-		}).Error("Managers can`t set offline status:")
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"err": err,
+			}).Error("Managers can`t set offline status:")
 
-		return
-	}
+			return
+		}
 
-	logger.Info("All manager set to offline:")
+		logger.Info("All manager set to offline:")
+	}
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
 	server := server()
 
+	go serveMetrics(server)
+
+	if config.AutoReply.Enabled {
+		go autoReplies.refresh()
+	}
+
 	go server.start()
+
+	if config.Cluster.Enabled {
+		go server.leases()
+	}
+
 	go server.commandQuery()
 	server.managerQuery()
 
@@ -134,3 +153,7 @@ func main() {
 	defer AMQPChannel.Close()
 	logger.WithFields(logrus.Fields{}).Info("Server stopped:")
 }
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
@@ -2,12 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
-	"log"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -70,16 +71,31 @@ type CannedPhrases struct {
 }
 
 type Status struct {
-	IsOnline bool `json:"isOnline"`
+	IsOnline bool   `json:"isOnline"`
+	Presence string `json:"presence"`
 }
 
 type Manager struct {
 	Id                   string `json:"id"`
 	SuccessLoginResponse *SuccessLoginResponse
 	connection           *websocket.Conn
-	requests             int
+	requests             int64
 	mu                   sync.Mutex
 	quit                 chan struct{}
+	cannedPhrases        CannedPhrasesState
+	pending              map[int]PendingRequest
+	presence             string
+	logger               atomic.Value
+	heartbeats           int
+	recorder             atomic.Value
+	dryRun               bool
+	instanceId           string
+	reason               string
+	outbox               chan Outgoing
+	chats                *ChatTracker
+	autoAccept           atomic.Value
+	accepting            map[int]time.Time
+	authReason           string
 }
 
 type ManagerStatus struct {
@@ -125,59 +141,74 @@ type ServerMessageLogout struct {
 	Jsonrpc string `json:"jsonrpc"`
 }
 
+func (manager *Manager) nextRequestId() int {
+	return int(atomic.AddInt64(&manager.requests, 1))
+}
+
 func (manager *Manager) subscribe() {
 	time.Sleep(time.Second * 1)
 
 	socketRegisterRequestParams := SocketRegisterRequestParams{"handle", "batch", nil}
-	manager.requests = manager.requests + 1
-	socketRegisterRequest := SocketRegisterRequest{manager.requests, "subscribe", socketRegisterRequestParams, "2.0"}
+	socketRegisterRequest := SocketRegisterRequest{manager.nextRequestId(), "subscribe", socketRegisterRequestParams, "2.0"}
 
 	manager.mu.Lock()
-	err := manager.connection.WriteJSON(socketRegisterRequest)
+	err := manager.sendJSON(socketRegisterRequest)
 	manager.mu.Unlock()
 
 	if err != nil {
-		logger.WithFields(logrus.Fields{
-			"manager": manager.Id,
-			"error":   err,
+		manager.log().WithFields(logrus.Fields{
+			"error": err,
 		}).Error("Can`t write subscribe request to socket:")
 	}
 
 }
 
-func (manager *Manager) auth() {
+func (manager *Manager) writeAuth() (int, error) {
+	var features [3]string
+	features[0] = "inbox"
+	features[1] = "multidevices"
+	features[2] = "support_admin_login"
+
+	if manager.instanceId == "" {
+		manager.instanceId = newUUID()
+	}
+
+	rmoState := RmoState{manager.availableForCalls()}
+	socketAuthRequestParams := SocketAuthRequestParams{
+		"login",
+		"3.1.2",
+		"3.1.2",
+		"3.1.2",
+		"web - 1.2.5 61a1133 Linux x86_64",
+		manager.away(),
+		manager.instanceId,
+		rmoState,
+		features,
+		manager.SuccessLoginResponse.AccessToken,
+	}
+
+	socketAuthRequest := SocketAuthRequest{manager.nextRequestId(), "cometan", socketAuthRequestParams, "2.0"}
+
+	manager.track(socketAuthRequest.ID, "auth", 0)
+
+	manager.mu.Lock()
+	err := manager.sendJSON(socketAuthRequest)
+	manager.mu.Unlock()
+
+	return socketAuthRequest.ID, err
+}
+
+func (manager *Manager) auth(reason string) {
+	manager.authReason = reason
+
 	go func() {
 		time.Sleep(time.Second * 2)
-		var features [3]string
-		features[0] = "inbox"
-		features[1] = "multidevices"
-		features[2] = "support_admin_login"
-
-		rmoState := RmoState{false}
-		socketAuthRequestParams := SocketAuthRequestParams{
-			"login",
-			"3.1.2",
-			"3.1.2",
-			"3.1.2",
-			"web - 1.2.5 61a1133 Linux x86_64",
-			false,
-			"1488c95-9e6f-51cc-bb-65fbf84e9b19",
-			rmoState,
-			features,
-			manager.SuccessLoginResponse.AccessToken,
-		}
 
-		manager.requests = manager.requests + 1
-		socketAuthRequest := SocketAuthRequest{manager.requests, "cometan", socketAuthRequestParams, "2.0"}
-
-		manager.mu.Lock()
-		err := manager.connection.WriteJSON(socketAuthRequest)
-		manager.mu.Unlock()
+		_, err := manager.writeAuth()
 
 		if err != nil {
-			logger.WithFields(logrus.Fields{
-				"manager": manager.Id,
-				"error":   err,
+			manager.log().WithFields(logrus.Fields{
+				"error": err,
 			}).Error("Can`t write auth request to socket:")
 		}
 
@@ -187,24 +218,10 @@ func (manager *Manager) auth() {
 func (manager *Manager) getCannedPhrases() {
 	time.Sleep(time.Second * 10)
 
-	cannedPhrasesParams := CannedPhrasesParams{"canned_phrases", 1, nil}
-
-	manager.requests = manager.requests + 1
-	cannedPhrases := CannedPhrases{manager.requests, "cometan", cannedPhrasesParams, "2.0"}
-
-	manager.mu.Lock()
-	err := manager.connection.WriteJSON(cannedPhrases)
-	manager.mu.Unlock()
-
-	if err != nil {
-		logger.WithFields(logrus.Fields{
-			"manager": manager.Id,
-			"error":   err,
-		}).Error("Can`t write auth request to socket:")
-	}
+	manager.requestCannedPhrases(nil)
 }
 
-func (manager *Manager) connectToSocket() {
+func (manager *Manager) dialSocket() error {
 	socketUrl := url.URL{Scheme: "wss", Host: manager.SuccessLoginResponse.EndpointList.Chatserver, Path: "/cometan"}
 
 	header := http.Header{}
@@ -213,194 +230,310 @@ func (manager *Manager) connectToSocket() {
 	chatSocketConnection, _, err := websocket.DefaultDialer.Dial(socketUrl.String(), header)
 
 	if err != nil {
-		log.Fatal("Can`t connect to chat socket:", err)
+		return err
 	}
 
 	manager.connection = chatSocketConnection
+
+	return nil
 }
 
 func (manager *Manager) reader(server *Server) {
 	for {
 		select {
 		case <-manager.quit:
-			logger.WithFields(logrus.Fields{
-				"manager": manager.Id,
-			}).Info("Reader quit:")
+			manager.log().Info("Reader quit:")
 			return
 		default:
 			_, message, err := manager.connection.ReadMessage()
 
 			if err != nil {
-				logger.WithFields(logrus.Fields{
+				select {
+				case <-manager.quit:
+					manager.log().Info("Reader quit:")
+					return
+				default:
+				}
+
+				manager.log().WithFields(logrus.Fields{
 					"error": err,
 				}).Error("Socket reader failed:")
+
+				if !manager.reconnect(server, err) {
+					return
+				}
+
+				continue
 			}
 
+			manager.recordFrame("in", message)
+
 			if string(message) != "." {
-				logger.WithFields(logrus.Fields{
-					"message": string(message),
-				}).Info("New message from server:")
+				manager.handleMessage(server, message, time.Now())
+			} else {
+				err = setLastOnline(manager.Id)
 
-				detectServerMessage := DetectServerMessage{}
+				if err != nil {
+					manager.log().WithFields(logrus.Fields{
+						"err": err,
+					}).Error("Manager can`t update online time:")
 
-				err = json.Unmarshal(message, &detectServerMessage)
+					return
+				}
 
-				if err != nil {
-					logger.WithFields(logrus.Fields{
-						"error": err,
-					}).Error("Can`t decode type response from socket:")
+				if entry := manager.heartbeat(); entry != nil {
+					entry.Debug("Recv pong:")
 				}
+			}
+		}
+	}
 
-				if detectServerMessage.Method == "handle" {
-					singleServerMessage := SingleServerMessage{}
+	close(manager.quit)
+}
 
-					if err != nil {
-						logger.WithFields(logrus.Fields{
-							"error": err,
-						}).Error("Can`t decode single response from socket:")
-					}
+func (manager *Manager) reconnect(server *Server, cause error) bool {
+	manager.publishStatus(StatusDisconnected, cause.Error())
 
-					err = publishToErp(message)
+	for attempt := 1; attempt <= config.Reconnect.Attempts; attempt++ {
+		select {
+		case <-manager.quit:
+			return false
+		case <-time.After(config.Reconnect.Delay * time.Duration(attempt)):
+		}
 
-					if err != nil {
-						logger.WithFields(logrus.Fields{
-							"error":   err,
-							"message": string(message),
-						}).Error("Failed to publish:")
-					}
+		manager.publishStatus(StatusReconnecting, fmt.Sprintf("attempt %d of %d", attempt, config.Reconnect.Attempts))
 
-					if singleServerMessage.Params.Name == "login_another_dev" {
+		err := manager.redial()
 
-						server.offline <- manager
+		if err == nil {
+			return true
+		}
 
-						logger.WithFields(logrus.Fields{
-							"message": detectServerMessage,
-						}).Error("Login from another dev:")
+		manager.log().WithFields(logrus.Fields{
+			"attempt": attempt,
+			"err":     err,
+		}).Warn("Manager can`t reconnect:")
+	}
 
-						close(manager.quit)
-					}
-				}
+	manager.reason = "reconnect failed"
+	server.offline <- manager
 
-				if detectServerMessage.Method == "batch" {
-					batchServerMessage := BatchServerMessage{}
-
-					if err != nil {
-						logger.WithFields(logrus.Fields{
-							"error": err,
-						}).Error("Can`t decode batch response from socket:")
-					}
-
-					for _, element := range batchServerMessage.Params {
-						debathServerMessage := DebathServerMessage{
-							ID:      batchServerMessage.ID,
-							Params:  element,
-							Method:  batchServerMessage.Method,
-							Jsonrpc: batchServerMessage.Jsonrpc,
-						}
-
-						message, err := json.Marshal(debathServerMessage)
-
-						if err != nil {
-							logger.WithFields(logrus.Fields{
-								"error": err,
-							}).Error("Can`t encode item of batch message from socket:")
-						}
-
-						err = publishToErp(message)
-
-						if err != nil {
-							logger.WithFields(logrus.Fields{
-								"error":   err,
-								"message": string(message),
-							}).Error("Failed to publish:")
-						}
-					}
+	return false
+}
 
-				}
+func (manager *Manager) redial() error {
+	response, err := refreshApiKey(manager)
 
-				manager.requests = manager.requests + 1
-				resultRequest := ResultRequest{detectServerMessage.ID, ResultRequestResult{}}
+	if err == nil {
+		manager.SuccessLoginResponse = response
+		err = manager.saveSession()
 
-				manager.mu.Lock()
-				err = manager.connection.WriteJSON(resultRequest)
-				manager.mu.Unlock()
+		if err != nil {
+			manager.log().WithFields(logrus.Fields{
+				"err": err,
+			}).Error("Manager can`t save session:")
+		}
+	} else {
+		err = manager.login()
 
-				if err != nil {
-					logger.WithFields(logrus.Fields{
-						"error": err,
-					}).Error("Can`t write result request to socket:")
-				}
+		if err != nil {
+			return err
+		}
+	}
 
-				logger.WithFields(logrus.Fields{
-					"body": resultRequest,
-				}).Info("Send Body:")
-			} else {
-				err = setLastOnline(manager.Id)
+	manager.mu.Lock()
+	manager.connection.Close()
+	err = manager.dialSocket()
+	manager.mu.Unlock()
 
-				if err != nil {
-					logger.WithFields(logrus.Fields{
-						"manager": manager.Id,
-						"err":     err,
-					}).Error("Manager can`t update online time:")
+	if err != nil {
+		return err
+	}
 
-					return
-				}
+	manager.subscribe()
+	manager.auth("reconnected")
+
+	return nil
+}
+
+func (manager *Manager) handleEvent(server *Server, name string, params map[string]interface{}, message []byte, correlationId string, receivedAt time.Time) {
+	if manager.backfilled(name, params, receivedAt) {
+		manager.log().WithFields(logrus.Fields{
+			"event": name,
+		}).Debug("Event already published as backfill:")
+
+		return
+	}
+
+	manager.autoReply(server, name, params, receivedAt)
+	manager.trackChat(name, params, receivedAt)
+
+	err := publishToErp(newEnvelope(frameEventType(name), manager.Id, correlationId, receivedAt, message))
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"error":   err,
+			"message": string(message),
+		}).Error("Failed to publish:")
+	}
+
+	manager.queueMirror(name, correlationId, receivedAt, message)
+
+	manager.autoAcceptOffer(name, params, receivedAt)
+}
+
+func (manager *Manager) handleMessage(server *Server, message []byte, receivedAt time.Time) {
+	detectServerMessage := DetectServerMessage{}
+
+	err := json.Unmarshal(message, &detectServerMessage)
+
+	manager.log().WithFields(logrus.Fields{
+		"rpc_id":  detectServerMessage.ID,
+		"message": string(message),
+	}).Debug("New message from server:")
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Can`t decode type response from socket:")
+	}
+
+	manager.handleCannedPhrases(detectServerMessage, message)
+	manager.handleResponse(server, detectServerMessage, message)
+
+	correlationId := newUUID()
+
+	if detectServerMessage.Method == "handle" {
+		singleServerMessage := SingleServerMessage{}
+
+		err = json.Unmarshal(message, &singleServerMessage)
+
+		if err != nil {
+			manager.log().WithFields(logrus.Fields{
+				"error": err,
+			}).Error("Can`t decode single response from socket:")
+		}
+
+		frame := struct {
+			Params map[string]interface{} `json:"params"`
+		}{}
 
-				logger.WithField("manager", manager.Id).Info("Recv pong:")
+		json.Unmarshal(message, &frame)
+
+		manager.handleEvent(server, singleServerMessage.Params.Name, frame.Params, message, correlationId, receivedAt)
+
+		if singleServerMessage.Params.Name == "login_another_dev" {
+			manager.log().WithFields(logrus.Fields{
+				"message": detectServerMessage,
+			}).Error("Login from another dev:")
+
+			manager.publishStatus(StatusKicked, singleServerMessage.Params.Name)
+
+			manager.reason = "kicked"
+			server.offline <- manager
+		}
+	}
+
+	if detectServerMessage.Method == "batch" {
+		batchServerMessage := BatchServerMessage{}
+
+		err = json.Unmarshal(message, &batchServerMessage)
+
+		if err != nil {
+			manager.log().WithFields(logrus.Fields{
+				"error": err,
+			}).Error("Can`t decode batch response from socket:")
+		}
+
+		for _, element := range batchServerMessage.Params {
+			debathServerMessage := DebathServerMessage{
+				ID:      batchServerMessage.ID,
+				Params:  element,
+				Method:  batchServerMessage.Method,
+				Jsonrpc: batchServerMessage.Jsonrpc,
+			}
+
+			message, err := json.Marshal(debathServerMessage)
+
+			if err != nil {
+				manager.log().WithFields(logrus.Fields{
+					"error": err,
+				}).Error("Can`t encode item of batch message from socket:")
 			}
+
+			var params map[string]interface{}
+
+			if len(element) > 1 {
+				params, _ = element[1].(map[string]interface{})
+			}
+
+			manager.handleEvent(server, batchItemName(element), params, message, correlationId, receivedAt)
 		}
+
 	}
 
-	close(manager.quit)
+	resultRequest := ResultRequest{detectServerMessage.ID, ResultRequestResult{}}
+
+	manager.mu.Lock()
+	err = manager.sendJSON(resultRequest)
+	manager.mu.Unlock()
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Can`t write result request to socket:")
+	}
+
+	manager.log().WithFields(logrus.Fields{
+		"rpc_id": resultRequest.ID,
+		"body":   resultRequest,
+	}).Debug("Send Body:")
 }
 
 func (manager *Manager) ticker() {
-	ticker := time.NewTicker(time.Second * 10)
+	ticker := time.NewTicker(config.Timeouts.Ping)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-manager.quit:
-			logger.WithFields(logrus.Fields{
-				"manager": manager.Id,
-			}).Info("Ticker quit:")
+			manager.log().Info("Ticker quit:")
 			return
 		case t := <-ticker.C:
 			manager.mu.Lock()
-			err := manager.connection.WriteMessage(websocket.TextMessage, []byte("."))
+			err := manager.sendMessage(websocket.TextMessage, []byte("."))
 			manager.mu.Unlock()
 
 			if err != nil {
-				logger.WithFields(logrus.Fields{
-					"manager": manager.Id,
-					"err":     err,
+				manager.log().WithFields(logrus.Fields{
+					"err": err,
 				}).Error("Send ping error:")
 
-				return
+				continue
+			}
+
+			if entry := manager.heartbeat(); entry != nil {
+				entry.WithFields(logrus.Fields{
+					"time": t,
+				}).Debug("Send ping:")
 			}
 
-			logger.WithFields(logrus.Fields{
-				"manager": manager.Id,
-				"time":    t,
-			}).Info("Send ping:")
+			manager.expirePending(t)
 
 		case <-interrupt:
 			manager.mu.Lock()
-			err := manager.connection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			err := manager.sendMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 			manager.mu.Unlock()
 
 			if err != nil {
-				logger.WithFields(logrus.Fields{
-					"manager": manager.Id,
-					"err":     err,
+				manager.log().WithFields(logrus.Fields{
+					"err": err,
 				}).Error("Send close socket message error on interrupt:")
 
 				return
 			}
 
-			logger.WithFields(logrus.Fields{
-				"manager": manager.Id,
-			}).Fatal("Interrupt:")
+			manager.log().Fatal("Interrupt:")
 
 			select {
 			case <-time.After(time.Second):
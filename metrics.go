@@ -0,0 +1,41 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+var metrics = expvar.NewMap("jivosite")
+
+func countMetric(name string, delta int64) {
+	metrics.Add(name, delta)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\"jivosite\": %s}\n", metrics.String())
+}
+
+func serveMetrics(server *Server) {
+	if config.Metrics.Addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", metricsHandler)
+	mux.HandleFunc("/chats", server.chatsHandler)
+
+	logger.WithFields(logrus.Fields{
+		"addr": config.Metrics.Addr,
+	}).Info("Server start metrics:")
+
+	err := http.ListenAndServe(config.Metrics.Addr, mux)
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Metrics server failed:")
+	}
+}
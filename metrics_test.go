@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	countMetric("outgoing_sent", 2)
+
+	recorder := httptest.NewRecorder()
+	metricsHandler(recorder, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	vars := map[string]map[string]int64{}
+
+	err := json.Unmarshal(recorder.Body.Bytes(), &vars)
+
+	if err != nil {
+		t.Fatalf("%v: %s", err, recorder.Body.String())
+	}
+
+	if len(vars) != 1 || vars["jivosite"]["outgoing_sent"] < 2 {
+		t.Errorf("got %s, want only the jivosite counters", recorder.Body.String())
+	}
+}
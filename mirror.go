@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+type MirrorJob struct {
+	Manager       *Manager
+	Name          string
+	CorrelationID string
+	ReceivedAt    time.Time
+	Message       []byte
+}
+
+type MirroredFile struct {
+	URL      string
+	Checksum string
+	Size     int
+}
+
+var mirrorClient = &http.Client{}
+
+var mirrorJobs chan MirrorJob
+
+func downloadMedia(fileUrl string) ([]byte, string, error) {
+	resp, err := mirrorClient.Get(fileUrl)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("download media failed with status %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(attachmentPolicy.MaxFileSize)+1))
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(data) > attachmentPolicy.MaxFileSize {
+		return nil, "", errors.New("media exceeds size limit")
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+func mirrorFile(fileUrl string, fileName string, mimeType string) (*MirroredFile, error) {
+	data, contentType, err := downloadMedia(fileUrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mimeType == "" {
+		mimeType = contentType
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	ext := path.Ext(sanitizeFileName(fileName))
+
+	if ext == "" {
+		if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+
+	key := fmt.Sprintf("jivosite/%s/%s/%s%s", checksum[:2], checksum[2:4], checksum, ext)
+
+	location, err := mediaStorage.Store(key, mimeType, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &MirroredFile{location, "sha256:" + checksum, len(data)}, nil
+}
+
+func mirrorMediaValue(manager *Manager, value interface{}) bool {
+	changed := false
+
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if mirrorMediaValue(manager, item) {
+				changed = true
+			}
+		}
+	case map[string]interface{}:
+		if media, ok := v["media"].(map[string]interface{}); ok {
+			if mirrorMediaObject(manager, media) {
+				changed = true
+			}
+		}
+
+		for key, item := range v {
+			if key != "media" && mirrorMediaValue(manager, item) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+func mirrorMediaObject(manager *Manager, media map[string]interface{}) bool {
+	fileUrl, _ := media["file_url"].(string)
+
+	if fileUrl == "" {
+		fileUrl, _ = media["file"].(string)
+	}
+
+	if !strings.HasPrefix(fileUrl, "http://") && !strings.HasPrefix(fileUrl, "https://") {
+		return false
+	}
+
+	fileName, _ := media["file_name"].(string)
+	mimeType, _ := media["mime_type"].(string)
+
+	mirrored, err := mirrorFile(fileUrl, fileName, mimeType)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"url": fileUrl,
+			"err": err,
+		}).Error("Can`t mirror media file:")
+
+		return false
+	}
+
+	media["original_file_url"] = fileUrl
+	media["file_url"] = mirrored.URL
+	media["file"] = mirrored.URL
+	media["checksum"] = mirrored.Checksum
+	media["file_size"] = mirrored.Size
+
+	if thumb, ok := media["thumb"].(string); ok && thumb != "" {
+		media["original_thumb"] = thumb
+		media["thumb"] = mirrored.URL
+	}
+
+	manager.log().WithFields(logrus.Fields{
+		"url":      mirrored.URL,
+		"checksum": mirrored.Checksum,
+	}).Info("Mirror media file:")
+
+	return true
+}
+
+func mirrorMedia(manager *Manager, message []byte) []byte {
+	if mediaStorage == nil || !strings.Contains(string(message), "\"media\"") {
+		return message
+	}
+
+	var decoded interface{}
+
+	err := json.Unmarshal(message, &decoded)
+
+	if err != nil {
+		return message
+	}
+
+	if !mirrorMediaValue(manager, decoded) {
+		return message
+	}
+
+	mirrored, err := json.Marshal(decoded)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Can`t encode mirrored message:")
+
+		return message
+	}
+
+	return mirrored
+}
+
+func startMirrorWorkers() {
+	if mediaStorage == nil {
+		return
+	}
+
+	mirrorJobs = make(chan MirrorJob, config.Media.Queue)
+
+	for i := 0; i < config.Media.Workers; i++ {
+		go mirrorWorker()
+	}
+}
+
+func mirrorWorker() {
+	for job := range mirrorJobs {
+		mirrored := mirrorMedia(job.Manager, job.Message)
+
+		if bytes.Equal(mirrored, job.Message) {
+			continue
+		}
+
+		err := publishToErp(newEnvelope("mirror."+job.Name, job.Manager.Id, job.CorrelationID, job.ReceivedAt, mirrored))
+
+		if err != nil {
+			job.Manager.log().WithFields(logrus.Fields{
+				"error":   err,
+				"message": string(mirrored),
+			}).Error("Failed to publish:")
+		}
+	}
+}
+
+func (manager *Manager) queueMirror(name string, correlationId string, receivedAt time.Time, message []byte) {
+	if mirrorJobs == nil || name == "agent_message" || !strings.Contains(string(message), "\"media\"") {
+		return
+	}
+
+	select {
+	case mirrorJobs <- MirrorJob{manager, name, correlationId, receivedAt, message}:
+	default:
+		countMetric("media_mirror_dropped", 1)
+
+		manager.log().WithFields(logrus.Fields{
+			"event": name,
+		}).Warn("Media mirror queue is full:")
+	}
+}
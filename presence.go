@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PresenceAvailable         = "available"
+	PresenceAway              = "away"
+	PresenceAvailableForCalls = "available_for_calls"
+)
+
+type PresenceCommand struct {
+	ManagerID string `json:"managerId"`
+	Params    struct {
+		Name     string `json:"name"`
+		Presence string `json:"presence"`
+	} `json:"params"`
+}
+
+type PresenceRequestParams struct {
+	Name     string   `json:"name"`
+	Away     bool     `json:"away"`
+	RmoState RmoState `json:"rmo_state"`
+}
+
+type PresenceRequest struct {
+	ID      int                   `json:"id"`
+	Method  string                `json:"method"`
+	Params  PresenceRequestParams `json:"params"`
+	Jsonrpc string                `json:"jsonrpc"`
+}
+
+type PresenceEventParams struct {
+	Name              string `json:"name"`
+	ManagerID         string `json:"manager_id"`
+	Presence          string `json:"presence"`
+	Away              bool   `json:"away"`
+	AvailableForCalls bool   `json:"available_for_calls"`
+}
+
+func validPresence(presence string) bool {
+	return presence == PresenceAvailable || presence == PresenceAway || presence == PresenceAvailableForCalls
+}
+
+func (manager *Manager) away() bool {
+	return manager.presence == PresenceAway
+}
+
+func (manager *Manager) availableForCalls() bool {
+	return manager.presence == PresenceAvailableForCalls
+}
+
+func (manager *Manager) setPresence(presence string) error {
+	if !validPresence(presence) {
+		return fmt.Errorf("unknown presence %q", presence)
+	}
+
+	manager.mu.Lock()
+	manager.presence = presence
+	request := PresenceRequest{
+		ID:     manager.nextRequestId(),
+		Method: "cometan",
+		Params: PresenceRequestParams{
+			Name:     "agent_state",
+			Away:     manager.away(),
+			RmoState: RmoState{manager.availableForCalls()},
+		},
+		Jsonrpc: "2.0",
+	}
+	manager.mu.Unlock()
+
+	err := manager.enqueue(Outgoing{RequestID: request.ID, Command: "presence", Request: request})
+
+	if err != nil {
+		return err
+	}
+
+	err = setPresence(manager.Id, presence)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Manager can`t store presence:")
+	}
+
+	return manager.publishPresence()
+}
+
+func (manager *Manager) publishPresence() error {
+	return publishServiceEvent(PresenceEventParams{
+		Name:              "presence",
+		ManagerID:         manager.Id,
+		Presence:          manager.presence,
+		Away:              manager.away(),
+		AvailableForCalls: manager.availableForCalls(),
+	})
+}
+
+func (manager *Manager) presenceCommand(command []byte) error {
+	presenceCommand := PresenceCommand{}
+
+	err := json.Unmarshal(command, &presenceCommand)
+
+	if err != nil {
+		return err
+	}
+
+	return manager.setPresence(presenceCommand.Params.Presence)
+}
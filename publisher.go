@@ -1,23 +1,57 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/streadway/amqp"
 	"time"
 )
 
-func publishToErp(message []byte) error {
+type CommandErrorParams struct {
+	Name      string `json:"name"`
+	ManagerID string `json:"manager_id"`
+	Command   string `json:"command"`
+	ChatID    int    `json:"chat_id"`
+	ClientID  int    `json:"client_id"`
+	PrivateID string `json:"private_id"`
+	Error     string `json:"error"`
+}
+
+type ServiceEvent struct {
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+	Jsonrpc string      `json:"jsonrpc"`
+}
+
+var publishToErp = publishToAMQP
+
+func publishToAMQP(envelope *Envelope) error {
 	var err error
 
+	body, err := json.Marshal(envelope)
+
+	if err != nil {
+		return err
+	}
+
+	exchange, key := config.Exchange.Name, config.Queues.ErpMessages
+
+	if exchange != "" {
+		key = envelope.routingKey()
+	}
+
 	err = AMQPChannel.Publish(
-		"",
-		"chat_to_erp_handle_messages",
+		exchange,
+		key,
 		false,
 		false,
 		amqp.Publishing{
-			DeliveryMode: amqp.Transient,
-			ContentType:  "application/json",
-			Body:         message,
-			Timestamp:    time.Now(),
+			Headers:       envelope.headers(),
+			DeliveryMode:  amqp.Transient,
+			ContentType:   "application/json",
+			CorrelationId: envelope.CorrelationID,
+			Type:          envelope.Type,
+			Body:          body,
+			Timestamp:     envelope.ReceivedAt,
 		})
 
 	if err != nil {
@@ -26,3 +60,57 @@ func publishToErp(message []byte) error {
 
 	return nil
 }
+
+func declareExchange() error {
+	if config.Exchange.Name == "" {
+		return nil
+	}
+
+	err := AMQPChannel.ExchangeDeclare(
+		config.Exchange.Name,
+		"topic",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+
+	if err != nil || !config.Exchange.BindQueue || config.Queues.ErpMessages == "" {
+		return err
+	}
+
+	return AMQPChannel.QueueBind(
+		config.Queues.ErpMessages,
+		config.Exchange.Prefix+".#",
+		config.Exchange.Name,
+		false,
+		nil,
+	)
+}
+
+func publishServiceEvent(params interface{}) error {
+	message, err := json.Marshal(ServiceEvent{"service", params, "2.0"})
+
+	if err != nil {
+		return err
+	}
+
+	meta := struct {
+		Params EventMeta `json:"params"`
+	}{}
+
+	err = json.Unmarshal(message, &meta)
+
+	if err != nil {
+		return err
+	}
+
+	return publishToErp(newEnvelope("service."+meta.Params.Name, meta.Params.ManagerID, meta.Params.PrivateID, time.Now(), message))
+}
+
+func publishCommandError(params CommandErrorParams) error {
+	params.Name = "command_error"
+
+	return publishServiceEvent(params)
+}
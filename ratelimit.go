@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+type RateLimiter struct {
+	manager *TokenBucket
+	chats   map[int]*TokenBucket
+}
+
+type Outgoing struct {
+	RequestID int
+	Command   string
+	ChatID    int
+	ClientID  int
+	PrivateID string
+	Request   interface{}
+	Pending   PendingRequest
+	QueuedAt  time.Time
+}
+
+var rateLimitedCommands = map[string]bool{
+	"agent_message": true,
+	"agent_image":   true,
+}
+
+func newTokenBucket(perMinute int, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (bucket *TokenBucket) refill(now time.Time) {
+	if now.Before(bucket.last) {
+		return
+	}
+
+	bucket.tokens = math.Min(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+}
+
+func (bucket *TokenBucket) reserve(now time.Time) time.Duration {
+	if bucket.rate <= 0 {
+		return 0
+	}
+
+	bucket.refill(now)
+	bucket.tokens = bucket.tokens - 1
+
+	if bucket.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+func newRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		manager: newTokenBucket(config.RateLimit.ManagerPerMinute, config.RateLimit.ManagerBurst),
+		chats:   make(map[int]*TokenBucket),
+	}
+}
+
+func (limiter *RateLimiter) reserve(chatId int, now time.Time) time.Duration {
+	if len(limiter.chats) > 1024 {
+		for id, bucket := range limiter.chats {
+			bucket.refill(now)
+
+			if bucket.tokens >= bucket.burst {
+				delete(limiter.chats, id)
+			}
+		}
+	}
+
+	chat, ok := limiter.chats[chatId]
+
+	if !ok {
+		chat = newTokenBucket(config.RateLimit.ChatPerMinute, config.RateLimit.ChatBurst)
+		limiter.chats[chatId] = chat
+	}
+
+	wait := limiter.manager.reserve(now)
+
+	if chatWait := chat.reserve(now); chatWait > wait {
+		wait = chatWait
+	}
+
+	return wait
+}
+
+func (manager *Manager) enqueue(outgoing Outgoing) error {
+	outgoing.QueuedAt = time.Now()
+
+	select {
+	case manager.outbox <- outgoing:
+		countMetric("outgoing_queued", 1)
+		return nil
+	default:
+		countMetric("outgoing_dropped", 1)
+		return errors.New("rate limited: outgoing queue is full")
+	}
+}
+
+func (manager *Manager) sender() {
+	limiter := newRateLimiter()
+
+	for {
+		select {
+		case <-manager.quit:
+			manager.dropOutbox()
+			manager.log().Info("Sender quit:")
+			return
+		case outgoing := <-manager.outbox:
+			wait := time.Duration(0)
+
+			if rateLimitedCommands[outgoing.Command] {
+				wait = limiter.reserve(outgoing.ChatID, time.Now())
+			}
+
+			if wait > 0 {
+				countMetric("outgoing_delayed", 1)
+				countMetric("outgoing_delay_ms", int64(wait/time.Millisecond))
+
+				manager.log().WithFields(logrus.Fields{
+					"command": outgoing.Command,
+					"chat_id": outgoing.ChatID,
+					"wait":    wait,
+				}).Debug("Outgoing message delayed:")
+
+				select {
+				case <-manager.quit:
+					countMetric("outgoing_dropped", 1)
+					manager.reject(outgoing, errors.New("manager went offline"))
+					manager.dropOutbox()
+					manager.log().Info("Sender quit:")
+					return
+				case <-time.After(wait):
+				}
+			}
+
+			pending := outgoing.Pending
+			pending.Command = outgoing.Command
+			pending.ChatID = outgoing.ChatID
+			pending.PrivateID = outgoing.PrivateID
+			pending.Delay = time.Since(outgoing.QueuedAt)
+
+			manager.trackPending(outgoing.RequestID, pending)
+
+			manager.mu.Lock()
+			err := manager.sendJSON(outgoing.Request)
+			manager.mu.Unlock()
+
+			if err != nil {
+				countMetric("outgoing_failed", 1)
+				manager.reject(outgoing, err)
+
+				continue
+			}
+
+			countMetric("outgoing_sent", 1)
+
+			manager.log().WithFields(logrus.Fields{
+				"command": outgoing.Command,
+				"chat_id": outgoing.ChatID,
+			}).Info("Server send command to socket:")
+		}
+	}
+}
+
+func (manager *Manager) dropOutbox() {
+	for {
+		select {
+		case outgoing := <-manager.outbox:
+			countMetric("outgoing_dropped", 1)
+			manager.reject(outgoing, errors.New("manager went offline"))
+		default:
+			return
+		}
+	}
+}
+
+func (manager *Manager) reject(outgoing Outgoing, cause error) {
+	manager.log().WithFields(logrus.Fields{
+		"command": outgoing.Command,
+		"chat_id": outgoing.ChatID,
+		"err":     cause,
+	}).Warn("Outgoing message rejected:")
+
+	dedupStore.forget(outgoing.ChatID, outgoing.PrivateID)
+
+	err := publishCommandError(CommandErrorParams{
+		ManagerID: manager.Id,
+		Command:   outgoing.Command,
+		ChatID:    outgoing.ChatID,
+		ClientID:  outgoing.ClientID,
+		PrivateID: outgoing.PrivateID,
+		Error:     cause.Error(),
+	})
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"command": outgoing.Command,
+			"error":   err,
+		}).Error("Failed to publish:")
+	}
+}
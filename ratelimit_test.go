@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	bucket := &TokenBucket{rate: 1, burst: 2, tokens: 2, last: start}
+
+	steps := []struct {
+		name string
+		at   time.Duration
+		wait time.Duration
+	}{
+		{"burst", 0, 0},
+		{"burst exhausted", 0, 0},
+		{"first overflow waits", 0, time.Second},
+		{"queued overflow waits longer", 0, 2 * time.Second},
+		{"half refilled", 500 * time.Millisecond, 2500 * time.Millisecond},
+		{"refill is capped at burst", 10 * time.Second, 0},
+		{"second token of the burst", 10 * time.Second, 0},
+		{"clock going back does not refill", 9 * time.Second, time.Second},
+	}
+
+	for _, step := range steps {
+		if got := bucket.reserve(start.Add(step.at)); got != step.wait {
+			t.Errorf("%s: got %s, want %s", step.name, got, step.wait)
+		}
+	}
+}
+
+func TestTokenBucketDisabled(t *testing.T) {
+	bucket := newTokenBucket(0, 0)
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		if wait := bucket.reserve(now); wait != 0 {
+			t.Fatalf("reserve %d: got %s with rate 0", i, wait)
+		}
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	config.RateLimit.ManagerPerMinute = 120
+	config.RateLimit.ManagerBurst = 3
+	config.RateLimit.ChatPerMinute = 60
+	config.RateLimit.ChatBurst = 1
+
+	limiter := newRateLimiter()
+	now := limiter.manager.last
+
+	steps := []struct {
+		name   string
+		chatId int
+		wait   time.Duration
+	}{
+		{"first message", 1, 0},
+		{"chat bucket is stricter", 1, time.Second},
+		{"other chat uses its own bucket", 2, 0},
+		{"manager bucket is stricter", 3, 500 * time.Millisecond},
+	}
+
+	for _, step := range steps {
+		if got := limiter.reserve(step.chatId, now); got != step.wait {
+			t.Errorf("%s: got %s, want %s", step.name, got, step.wait)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+type BackfillFrame struct {
+	Method  string                 `json:"method"`
+	Params  map[string]interface{} `json:"params"`
+	Jsonrpc string                 `json:"jsonrpc"`
+}
+
+var historyEvents = map[string]string{
+	"client": "client_message",
+	"agent":  "agent_message",
+	"system": "system_message",
+}
+
+func (tracker *ChatTracker) missing(chatId int, messageId string, sentAt *time.Time, fresh bool) bool {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	key := dedupKey(chatId, messageId)
+
+	if messageId != "" {
+		if _, ok := tracker.seen[key]; ok {
+			return false
+		}
+	}
+
+	if sentAt == nil && messageId == "" {
+		return false
+	}
+
+	if chat, ok := tracker.chats[chatId]; ok && sentAt != nil && !fresh {
+		last := chat.AcceptedAt
+
+		if chat.LastMessageAt != nil {
+			last = *chat.LastMessageAt
+		}
+
+		if !sentAt.After(last) {
+			return false
+		}
+	}
+
+	if messageId != "" {
+		tracker.seen[key] = time.Now()
+	}
+
+	return true
+}
+
+func (tracker *ChatTracker) observe(chatId int, messageId string, at time.Time) bool {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	key := dedupKey(chatId, messageId)
+
+	if _, ok := tracker.seen[key]; ok {
+		return true
+	}
+
+	tracker.seen[key] = at
+
+	return false
+}
+
+func (manager *Manager) backfilled(event string, params map[string]interface{}, at time.Time) bool {
+	if manager.chats == nil || params == nil || chatEvents[event] != "message" {
+		return false
+	}
+
+	chatId := intParam(params, "chat_id")
+	messageId := stringParam(params, "message_id", "id")
+
+	if chatId <= 0 || messageId == "" {
+		return false
+	}
+
+	return manager.chats.observe(chatId, messageId, at)
+}
+
+func (manager *Manager) publishBackfill(name string, params map[string]interface{}, correlationId string, at time.Time) error {
+	params["name"] = name
+	params["backfilled"] = true
+
+	message, err := json.Marshal(BackfillFrame{"handle", params, "2.0"})
+
+	if err != nil {
+		return err
+	}
+
+	envelope := newEnvelope(frameEventType(name), manager.Id, correlationId, at, message)
+	envelope.Backfilled = true
+
+	return publishToErp(envelope)
+}
+
+func historyParams(message HistoryMessage) map[string]interface{} {
+	params := map[string]interface{}{}
+
+	encoded, err := json.Marshal(message)
+
+	if err == nil {
+		json.Unmarshal(encoded, &params)
+	}
+
+	return params
+}
+
+func (manager *Manager) reconcileChat(chatId int, fresh bool, correlationId string) (int, error) {
+	history, err := manager.fetchChatHistory(chatId, config.Chats.HistoryLimit)
+
+	if err != nil {
+		return 0, err
+	}
+
+	messages := []HistoryMessage{}
+
+	for _, message := range history.Messages {
+		normalized := normalizeHistoryMessage(chatId, message)
+
+		if mediaStorage != nil && normalized.Author != "agent" {
+			mirrorMediaValue(manager, message)
+			normalized.Media = message["media"]
+		}
+
+		messages = append(messages, normalized)
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		if messages[i].SentAt == nil || messages[j].SentAt == nil {
+			return false
+		}
+
+		return messages[i].SentAt.Before(*messages[j].SentAt)
+	})
+
+	backfilled := 0
+
+	for _, message := range messages {
+		if !manager.chats.missing(chatId, message.MessageID, message.SentAt, fresh) {
+			continue
+		}
+
+		at := time.Now()
+
+		if message.SentAt != nil {
+			at = *message.SentAt
+		}
+
+		name := historyEvents[message.Author]
+		params := historyParams(message)
+
+		err = manager.publishBackfill(name, params, correlationId, at)
+
+		if err != nil {
+			return backfilled, err
+		}
+
+		backfilled = backfilled + 1
+
+		if message.Author != "system" {
+			manager.trackChat(name, params, at)
+		}
+	}
+
+	return backfilled, nil
+}
+
+func (manager *Manager) reconcile(reason string) {
+	if !config.Chats.Reconcile || manager.chats == nil {
+		return
+	}
+
+	correlationId := newUUID()
+	chats := manager.chats.list(false)
+	known := make(map[int]bool)
+	fresh := make(map[int]bool)
+
+	for _, chat := range chats {
+		known[chat.ChatID] = true
+	}
+
+	active, err := getActiveChats(manager)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Warn("Manager can`t get active chats:")
+	}
+
+	if active != nil {
+		for _, params := range active.Chats {
+			chatId := intParam(params, "chat_id")
+
+			if chatId <= 0 {
+				chatId = intParam(params, "id")
+				params["chat_id"] = float64(chatId)
+			}
+
+			if chatId <= 0 || known[chatId] {
+				continue
+			}
+
+			known[chatId] = true
+			fresh[chatId] = true
+			now := time.Now()
+
+			err = manager.publishBackfill("chat_accepted", params, correlationId, now)
+
+			if err != nil {
+				manager.log().WithFields(logrus.Fields{
+					"chat_id": chatId,
+					"error":   err,
+				}).Error("Failed to publish:")
+			}
+
+			manager.trackChat("chat_accepted", params, now)
+			chats = append(chats, Chat{ChatID: chatId})
+		}
+	}
+
+	backfilled := 0
+
+	for _, chat := range chats {
+		count, err := manager.reconcileChat(chat.ChatID, fresh[chat.ChatID], correlationId)
+		backfilled = backfilled + count
+
+		if err != nil {
+			manager.log().WithFields(logrus.Fields{
+				"chat_id": chat.ChatID,
+				"err":     err,
+			}).Error("Manager can`t reconcile chat:")
+		}
+	}
+
+	countMetric("backfilled_events", int64(backfilled))
+
+	manager.log().WithFields(logrus.Fields{
+		"reason":     reason,
+		"chats":      len(chats),
+		"backfilled": backfilled,
+	}).Info("Manager chats reconciled:")
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Frame struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Manager   string    `json:"manager"`
+	Frame     string    `json:"frame"`
+}
+
+type Recorder struct {
+	Path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+type RecordCommand struct {
+	ManagerID string `json:"managerId"`
+	Params    struct {
+		Name    string `json:"name"`
+		Enabled bool   `json:"enabled"`
+	} `json:"params"`
+}
+
+func newRecorder(managerId string) (*Recorder, error) {
+	err := os.MkdirAll(config.Record.Dir, 0700)
+
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(config.Record.Dir, fmt.Sprintf("%s-%s.jsonl", managerId, time.Now().Format("20060102-150405")))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{Path: path, file: file}, nil
+}
+
+func (recorder *Recorder) write(frame Frame) error {
+	if !config.Log.Verbose {
+		frame.Frame = redactFrame(frame.Frame)
+	}
+
+	data, err := json.Marshal(frame)
+
+	if err != nil {
+		return err
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	_, err = recorder.file.Write(append(data, '\n'))
+
+	return err
+}
+
+func (recorder *Recorder) close() error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	return recorder.file.Close()
+}
+
+func recordingEnabled(managerId string) bool {
+	if config.Record.Dir == "" {
+		return false
+	}
+
+	if len(config.Record.Managers) == 0 {
+		return true
+	}
+
+	for _, id := range config.Record.Managers {
+		if id == managerId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (manager *Manager) startRecording() error {
+	if config.Record.Dir == "" {
+		return fmt.Errorf("RECORD_DIR is not configured")
+	}
+
+	if recorder, ok := manager.recorder.Load().(*Recorder); ok && recorder != nil {
+		return nil
+	}
+
+	recorder, err := newRecorder(manager.Id)
+
+	if err != nil {
+		return err
+	}
+
+	manager.recorder.Store(recorder)
+
+	manager.log().WithFields(logrus.Fields{
+		"path": recorder.Path,
+	}).Info("Manager recording started:")
+
+	return nil
+}
+
+func (manager *Manager) stopRecording() {
+	recorder, ok := manager.recorder.Load().(*Recorder)
+
+	if !ok || recorder == nil {
+		return
+	}
+
+	manager.recorder.Store((*Recorder)(nil))
+
+	err := recorder.close()
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Can`t close recording:")
+	}
+
+	manager.log().WithFields(logrus.Fields{
+		"path": recorder.Path,
+	}).Info("Manager recording stopped:")
+}
+
+func (manager *Manager) recordFrame(direction string, data []byte) {
+	recorder, ok := manager.recorder.Load().(*Recorder)
+
+	if !ok || recorder == nil {
+		return
+	}
+
+	err := recorder.write(Frame{time.Now(), direction, manager.Id, string(data)})
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Can`t record frame:")
+	}
+}
+
+func (manager *Manager) sendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	return manager.sendMessage(websocket.TextMessage, data)
+}
+
+func (manager *Manager) sendMessage(messageType int, data []byte) error {
+	manager.recordFrame("out", data)
+
+	if manager.dryRun {
+		return nil
+	}
+
+	return manager.connection.WriteMessage(messageType, data)
+}
+
+func (manager *Manager) recordCommand(command []byte) error {
+	recordCommand := RecordCommand{}
+
+	err := json.Unmarshal(command, &recordCommand)
+
+	if err != nil {
+		return err
+	}
+
+	if recordCommand.Params.Enabled {
+		return manager.startRecording()
+	}
+
+	manager.stopRecording()
+
+	return nil
+}
+
+func replay(path string, out io.Writer) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	publish := publishToErp
+
+	defer func() {
+		publishToErp = publish
+	}()
+
+	publishToErp = func(envelope *Envelope) error {
+		message, err := json.Marshal(envelope)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(out, string(message))
+
+		return err
+	}
+
+	server := server()
+
+	go func() {
+		for range server.offline {
+		}
+	}()
+
+	managers := make(map[string]*Manager)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		frame := Frame{}
+
+		err = json.Unmarshal(scanner.Bytes(), &frame)
+
+		if err != nil {
+			return err
+		}
+
+		if frame.Direction != "in" || frame.Frame == "." || frame.Frame == "" {
+			continue
+		}
+
+		manager, ok := managers[frame.Manager]
+
+		if !ok {
+			manager = &Manager{Id: frame.Manager, dryRun: true, quit: make(chan struct{}), chats: newChatTracker()}
+			managers[frame.Manager] = manager
+		}
+
+		manager.handleMessage(server, []byte(frame.Frame), frame.Time)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorderRedactsFrames(t *testing.T) {
+	config.Log.Verbose = false
+	config.Record.Dir = t.TempDir()
+
+	recorder, err := newRecorder("42")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := []string{
+		`{"id":1,"method":"cometan","params":{"name":"auth","token":"0123456789abcdef0123456789abcdef"},"jsonrpc":"2.0"}`,
+		`{"id":7,"method":"handle","params":{"name":"client_message","chat_id":123,"message":"call +380 67 123 45 67"},"jsonrpc":"2.0"}`,
+		`not json, mail anna@example.com`,
+		`.`,
+	}
+
+	for _, frame := range frames {
+		err = recorder.write(Frame{time.Now(), "in", "42", frame})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder.close()
+
+	data, err := os.ReadFile(recorder.Path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"0123456789abcdef", "380 67", "anna@example.com"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("capture contains %q:\n%s", secret, data)
+		}
+	}
+
+	for _, kept := range []string{`\"chat_id\":123`, `\"name\":\"client_message\"`, `"frame":"."`} {
+		if !strings.Contains(string(data), kept) {
+			t.Errorf("capture lost %s:\n%s", kept, data)
+		}
+	}
+}
+
+func TestReplayCapture(t *testing.T) {
+	out := bytes.Buffer{}
+
+	err := replay("testdata/capture.jsonl", &out)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"jivosite.client_message", "jivosite.agent_message", "jivosite.chat_finished"}
+
+	got := []Envelope{}
+	scanner := bufio.NewScanner(&out)
+
+	for scanner.Scan() {
+		envelope := Envelope{}
+
+		err = json.Unmarshal(scanner.Bytes(), &envelope)
+
+		if err != nil {
+			t.Fatalf("%v: %s", err, scanner.Text())
+		}
+
+		got = append(got, envelope)
+	}
+
+	if reflect.ValueOf(publishToErp).Pointer() != reflect.ValueOf(publishToAMQP).Pointer() {
+		t.Errorf("replay left its publisher in place")
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d envelopes, want %d:\n%s", len(got), len(want), out.String())
+	}
+
+	for i, envelope := range got {
+		payload := struct {
+			Params json.RawMessage `json:"params"`
+		}{}
+
+		json.Unmarshal(envelope.Payload, &payload)
+
+		if envelope.Type != want[i] || envelope.ManagerID != "42" {
+			t.Errorf("envelope %d: got %s for %s, want %s", i, envelope.Type, envelope.ManagerID, want[i])
+		}
+
+		if !strings.Contains(string(payload.Params), `"chat_id":123`) {
+			t.Errorf("envelope %d: chat_id missing from %s", i, payload.Params)
+		}
+
+		if envelope.Sequence <= 0 {
+			t.Errorf("envelope %d: sequence %d", i, envelope.Sequence)
+		}
+	}
+}
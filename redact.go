@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type RedactHook struct{}
+
+var redactKeys = map[string]bool{
+	"access_token":     true,
+	"token":            true,
+	"password":         true,
+	"pass":             true,
+	"authorization":    true,
+	"signature":        true,
+	"x-amz-signature":  true,
+	"policy":           true,
+	"credential":       true,
+	"x-amz-credential": true,
+	"phone":            true,
+	"email":            true,
+	"message":          true,
+	"text":             true,
+	"src":              true,
+}
+
+var redactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	regexp.MustCompile(`\+\d[\d\s\-()]{8,}\d|\b\d{10,12}\b`),
+	regexp.MustCompile(`(?i)(token|signature|password|credential)=[^&\s"]+`),
+}
+
+func (hook *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (hook *RedactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = redactString(entry.Message)
+
+	for key, value := range entry.Data {
+		entry.Data[key] = redactField(key, value)
+	}
+
+	return nil
+}
+
+func redactString(s string) string {
+	for _, pattern := range redactPatterns {
+		s = pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if i := strings.Index(match, "="); i > 0 && !strings.Contains(match, "@") {
+				return match[:i+1] + "***"
+			}
+
+			return "***"
+		})
+	}
+
+	return s
+}
+
+func redactMasked(value interface{}) string {
+	return fmt.Sprintf("[redacted:%d]", len(fmt.Sprint(value)))
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if redactKeys[strings.ToLower(key)] {
+				if _, ok := item.(map[string]interface{}); !ok && item != nil {
+					v[key] = redactMasked(item)
+					continue
+				}
+			}
+
+			v[key] = redactValue(item)
+		}
+
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+
+		return v
+	case string:
+		return redactString(v)
+	}
+
+	return value
+}
+
+func isStructured(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	}
+
+	return false
+}
+
+func redactJSON(data []byte) (interface{}, bool) {
+	var decoded interface{}
+
+	if json.Unmarshal(data, &decoded) != nil {
+		return nil, false
+	}
+
+	return redactValue(decoded), true
+}
+
+func redactFrame(frame string) string {
+	decoded, ok := redactJSON([]byte(frame))
+
+	if !ok {
+		return redactString(frame)
+	}
+
+	data, err := json.Marshal(decoded)
+
+	if err != nil {
+		return redactString(frame)
+	}
+
+	return string(data)
+}
+
+func redactField(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, int, int64, float64, logrus.Level, time.Time:
+		return value
+	case error:
+		return redactString(v.Error())
+	case string:
+		if decoded, ok := redactJSON([]byte(v)); ok && isStructured(decoded) {
+			return decoded
+		}
+
+		if redactKeys[strings.ToLower(key)] {
+			return redactMasked(v)
+		}
+
+		return redactString(v)
+	case []byte:
+		return redactField(key, string(v))
+	}
+
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return redactString(fmt.Sprint(value))
+	}
+
+	if decoded, ok := redactJSON(data); ok && isStructured(decoded) {
+		return decoded
+	}
+
+	return redactString(fmt.Sprint(value))
+}
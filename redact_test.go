@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"testing"
+)
+
+func TestRedactString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"nothing to hide", "nothing to hide"},
+		{"mail anna@example.com now", "mail *** now"},
+		{"call +380 (67) 123-45-67", "call ***"},
+		{"call 0671234567", "call ***"},
+		{"chat 123456 is open", "chat 123456 is open"},
+		{"https://s3/x?X-Amz-Signature=abc&Token=def", "https://s3/x?X-Amz-Signature=***&Token=***"},
+		{"password=hunter2 user=anna", "password=*** user=anna"},
+	}
+
+	for _, test := range tests {
+		if got := redactString(test.in); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestRedactHookFire(t *testing.T) {
+	entry := logrus.NewEntry(logrus.New())
+	entry.Message = "Login failed for anna@example.com:"
+	entry.Data = logrus.Fields{
+		"manager":  "42",
+		"chat_id":  123,
+		"password": "hunter2",
+		"error":    errors.New("token=abc rejected"),
+		"message":  `{"params":{"name":"client_message","message":"hi","chat_id":5}}`,
+		"body":     map[string]interface{}{"access_token": "abc", "nested": map[string]interface{}{"email": "a@b.co"}},
+		"text":     "plain visitor text",
+	}
+
+	err := (&RedactHook{}).Fire(entry)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := logrus.Fields{
+		"manager":  "42",
+		"chat_id":  123,
+		"password": "[redacted:7]",
+		"error":    "token=*** rejected",
+		"message": map[string]interface{}{
+			"params": map[string]interface{}{"name": "client_message", "message": "[redacted:2]", "chat_id": float64(5)},
+		},
+		"body": map[string]interface{}{"access_token": "[redacted:3]", "nested": map[string]interface{}{"email": "[redacted:6]"}},
+		"text": "[redacted:18]",
+	}
+
+	if entry.Message != "Login failed for ***:" {
+		t.Errorf("message: got %q", entry.Message)
+	}
+
+	for key, value := range want {
+		if !reflect.DeepEqual(entry.Data[key], value) {
+			t.Errorf("%s: got %#v, want %#v", key, entry.Data[key], value)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const pendingTimeout = time.Minute
+
+type RpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type RpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *RpcError       `json:"error"`
+}
+
+type PendingRequest struct {
+	Command   string
+	ChatID    int
+	PrivateID string
+	SentAt    time.Time
+	Delay     time.Duration
+	Phrase    *CannedPhrase
+	Accepted  *AutoAcceptedEventParams
+}
+
+type CommandResultParams struct {
+	Name      string `json:"name"`
+	ManagerID string `json:"manager_id"`
+	Command   string `json:"command"`
+	RequestID int    `json:"request_id"`
+	ChatID    int    `json:"chat_id"`
+	PrivateID string `json:"private_id,omitempty"`
+	Ok        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	DelayMs   int64  `json:"delay_ms,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+func (manager *Manager) track(id int, command string, chatId int) {
+	manager.trackPending(id, PendingRequest{Command: command, ChatID: chatId})
+}
+
+func (manager *Manager) trackPending(id int, pending PendingRequest) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if manager.pending == nil {
+		manager.pending = make(map[int]PendingRequest)
+	}
+
+	pending.SentAt = time.Now()
+	manager.pending[id] = pending
+}
+
+func (manager *Manager) expirePending(now time.Time) {
+	expired := make(map[int]PendingRequest)
+
+	manager.mu.Lock()
+	for id, pending := range manager.pending {
+		if now.Sub(pending.SentAt) > pendingTimeout {
+			expired[id] = pending
+			delete(manager.pending, id)
+		}
+	}
+	manager.mu.Unlock()
+
+	for id, pending := range expired {
+		manager.log().WithFields(logrus.Fields{
+			"command":    pending.Command,
+			"request_id": id,
+			"chat_id":    pending.ChatID,
+		}).Warn("Socket request timed out:")
+
+		pending.Accepted = nil
+
+		manager.resolve(nil, pending, RpcResponse{ID: id, Error: &RpcError{Message: "timeout"}})
+	}
+}
+
+func (manager *Manager) handleResponse(server *Server, detectServerMessage DetectServerMessage, message []byte) {
+	if detectServerMessage.Method != "" {
+		return
+	}
+
+	manager.mu.Lock()
+	pending, ok := manager.pending[detectServerMessage.ID]
+	delete(manager.pending, detectServerMessage.ID)
+	manager.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	response := RpcResponse{}
+
+	err := json.Unmarshal(message, &response)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Can`t decode rpc response from socket:")
+
+		return
+	}
+
+	manager.resolve(server, pending, response)
+}
+
+func (manager *Manager) resolve(server *Server, pending PendingRequest, response RpcResponse) {
+	if pending.Command == "auth" {
+		manager.authenticated(server, response)
+
+		return
+	}
+
+	if pending.Phrase != nil {
+		manager.cannedPhraseResult(pending, response)
+
+		return
+	}
+
+	result := CommandResultParams{
+		Name:      "command_result",
+		ManagerID: manager.Id,
+		Command:   pending.Command,
+		RequestID: response.ID,
+		ChatID:    pending.ChatID,
+		PrivateID: pending.PrivateID,
+		Ok:        response.Error == nil,
+		DelayMs:   int64(pending.Delay / time.Millisecond),
+	}
+
+	if response.Error != nil {
+		result.Error = response.Error.Message
+	}
+
+	dedupStore.complete(result)
+
+	err := publishServiceEvent(result)
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"command": pending.Command,
+			"error":   err,
+		}).Error("Failed to publish:")
+	}
+
+	if pending.Accepted != nil {
+		manager.autoAccepted(*pending.Accepted, result.Ok)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Schedule struct {
+	Hours string   `json:"hours"`
+	Days  []string `json:"days"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseClock(value string) (int, error) {
+	var hours, minutes int
+
+	_, err := fmt.Sscanf(strings.TrimSpace(value), "%d:%d", &hours, &minutes)
+
+	if err != nil || hours < 0 || hours > 24 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	return hours*60 + minutes, nil
+}
+
+func (schedule Schedule) hours() (int, int, error) {
+	parts := strings.Split(schedule.Hours, "-")
+
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid hours %q, expected HH:MM-HH:MM", schedule.Hours)
+	}
+
+	start, err := parseClock(parts[0])
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end, err := parseClock(parts[1])
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return start, end, nil
+}
+
+func (schedule Schedule) validate() error {
+	if schedule.Hours != "" {
+		if _, _, err := schedule.hours(); err != nil {
+			return err
+		}
+	}
+
+	for _, day := range schedule.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+	}
+
+	return nil
+}
+
+func (schedule Schedule) within(at time.Time, timezone string) bool {
+	location, err := time.LoadLocation(timezone)
+
+	if err == nil {
+		at = at.In(location)
+	}
+
+	if len(schedule.Days) > 0 {
+		found := false
+
+		for _, day := range schedule.Days {
+			if weekdays[strings.ToLower(day)] == at.Weekday() {
+				found = true
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if schedule.Hours == "" {
+		return true
+	}
+
+	start, end, err := schedule.hours()
+
+	if err != nil {
+		return false
+	}
+
+	minute := at.Hour()*60 + at.Minute()
+
+	if start <= end {
+		return minute >= start && minute < end
+	}
+
+	return minute >= start || minute < end
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleWithin(t *testing.T) {
+	monday := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		timezone string
+		at       time.Time
+		want     bool
+	}{
+		{"empty schedule", Schedule{}, "UTC", monday.Add(3 * time.Hour), true},
+		{"inside day hours", Schedule{Hours: "09:00-18:00"}, "UTC", monday.Add(9 * time.Hour), true},
+		{"end is exclusive", Schedule{Hours: "09:00-18:00"}, "UTC", monday.Add(18 * time.Hour), false},
+		{"before day hours", Schedule{Hours: "09:00-18:00"}, "UTC", monday.Add(8*time.Hour + 59*time.Minute), false},
+		{"overnight evening", Schedule{Hours: "22:00-06:00"}, "UTC", monday.Add(23 * time.Hour), true},
+		{"overnight morning", Schedule{Hours: "22:00-06:00"}, "UTC", monday.Add(5*time.Hour + 59*time.Minute), true},
+		{"overnight daytime", Schedule{Hours: "22:00-06:00"}, "UTC", monday.Add(12 * time.Hour), false},
+		{"until midnight", Schedule{Hours: "18:00-24:00"}, "UTC", monday.Add(23*time.Hour + 59*time.Minute), true},
+		{"matching day", Schedule{Days: []string{"Mon", "tue"}}, "UTC", monday, true},
+		{"other day", Schedule{Days: []string{"sat", "sun"}}, "UTC", monday, false},
+		{"day in timezone", Schedule{Days: []string{"sun"}}, "America/New_York", monday.Add(2 * time.Hour), true},
+		{"hours in timezone", Schedule{Hours: "09:00-18:00"}, "Europe/Kiev", monday.Add(7 * time.Hour), true},
+		{"invalid hours", Schedule{Hours: "late"}, "UTC", monday, false},
+	}
+
+	for _, test := range tests {
+		if got := test.schedule.within(test.at, test.timezone); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	tests := []struct {
+		schedule Schedule
+		valid    bool
+	}{
+		{Schedule{}, true},
+		{Schedule{Hours: "22:00-06:00", Days: []string{"FRI", "sat"}}, true},
+		{Schedule{Hours: "00:00-24:00"}, true},
+		{Schedule{Hours: "09:00"}, false},
+		{Schedule{Hours: "09:60-18:00"}, false},
+		{Schedule{Hours: "09:00-24:01"}, false},
+		{Schedule{Days: []string{"monday"}}, false},
+	}
+
+	for _, test := range tests {
+		err := test.schedule.validate()
+
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: got %v, want valid %v", test.schedule, err, test.valid)
+		}
+	}
+}
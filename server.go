@@ -1,15 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/sirupsen/logrus"
-	"image"
+	"github.com/streadway/amqp"
+	"hash/fnv"
 	_ "image/jpeg"
 	_ "image/png"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 type WhatCommand struct {
@@ -94,7 +97,9 @@ type Server struct {
 	managers map[string]*Manager
 	online   chan *Manager
 	offline  chan *Manager
-	command  chan []byte
+	owned    chan []string
+	workers  []chan amqp.Delivery
+	mu       sync.RWMutex
 }
 
 func server() *Server {
@@ -102,15 +107,36 @@ func server() *Server {
 		online:   make(chan *Manager),
 		offline:  make(chan *Manager),
 		managers: make(map[string]*Manager),
-		command:  make(chan []byte),
+		owned:    make(chan []string),
 	}
 }
 
+func (server *Server) manager(id string) (*Manager, bool) {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+
+	manager, ok := server.managers[id]
+
+	return manager, ok
+}
+
+func (server *Server) add(manager *Manager) {
+	server.mu.Lock()
+	server.managers[manager.Id] = manager
+	server.mu.Unlock()
+}
+
+func (server *Server) remove(id string) {
+	server.mu.Lock()
+	delete(server.managers, id)
+	server.mu.Unlock()
+}
+
 func (server *Server) managerQuery() {
 	logger.WithFields(logrus.Fields{}).Info("Server start manager query:")
 
 	msgs, err := AMQPChannel.Consume(
-		"erp_chat_manager_status",
+		config.Queues.ManagerStatus,
 		"",
 		false,
 		false,
@@ -122,39 +148,74 @@ func (server *Server) managerQuery() {
 
 	forever := make(chan bool)
 
-	go func() {
-		for d := range msgs {
-			managerStatus := &ManagerStatus{}
+	go server.statusLoop(msgs, true)
 
-			err := json.Unmarshal(d.Body, &managerStatus)
+	if config.Cluster.Enabled {
+		queue, err := declareNodeQueue(config.Queues.ManagerStatus)
+		failOnError(err, "Failed to declare a queue")
 
-			if err != nil {
-				logger.WithFields(logrus.Fields{
-					"error": err,
-				}).Error("Can`t decode manager query callBack:")
-			}
+		msgs, err := AMQPChannel.Consume(
+			queue,
+			"",
+			false,
+			false,
+			false,
+			false,
+			nil,
+		)
+		failOnError(err, "Failed to register a consumer")
 
-			managerStatus.Manager.requests = 0
-			manager := managerStatus.Manager
+		go server.statusLoop(msgs, false)
+	}
 
-			if managerStatus.Status.IsOnline == true {
-				server.online <- manager
-			} else {
-				server.offline <- manager
-			}
+	<-forever
+}
+
+func (server *Server) statusLoop(msgs <-chan amqp.Delivery, shared bool) {
+	for d := range msgs {
+		managerStatus := &ManagerStatus{}
+
+		err := json.Unmarshal(d.Body, &managerStatus)
 
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("Can`t decode manager query callBack:")
+		}
+
+		managerStatus.Manager.requests = 0
+		managerStatus.Manager.presence = managerStatus.Status.Presence
+		manager := managerStatus.Manager
+
+		if shared && routeToOwner(config.Queues.ManagerStatus, manager.Id, d.Body) {
 			d.Ack(false)
+			continue
 		}
-	}()
 
-	<-forever
+		if managerStatus.Status.IsOnline == true {
+			server.online <- manager
+		} else {
+			server.offline <- manager
+		}
+
+		d.Ack(false)
+	}
 }
 
 func (server *Server) commandQuery() {
-	logger.WithFields(logrus.Fields{}).Info("Server start command query:")
+	logger.WithFields(logrus.Fields{
+		"workers":  config.Commands.Workers,
+		"prefetch": config.Commands.Prefetch,
+	}).Info("Server start command query:")
 
-	msgs, err := AMQPChannel.Consume(
-		"erp_chat_manager_command",
+	channel, err := AMQPConnection.Channel()
+	failOnError(err, "Failed to open a channel")
+
+	err = channel.Qos(config.Commands.Prefetch, 0, false)
+	failOnError(err, "Failed to set QoS")
+
+	msgs, err := channel.Consume(
+		config.Queues.ManagerCommand,
 		"",
 		false,
 		false,
@@ -164,41 +225,106 @@ func (server *Server) commandQuery() {
 	)
 	failOnError(err, "Failed to register a consumer")
 
+	server.workers = make([]chan amqp.Delivery, config.Commands.Workers)
+
+	for i := range server.workers {
+		server.workers[i] = make(chan amqp.Delivery, config.Commands.Prefetch)
+		go server.worker(server.workers[i])
+	}
+
 	forever := make(chan bool)
 
-	go func() {
-		for d := range msgs {
-			server.command <- d.Body
+	go server.commandLoop(msgs, true)
+
+	if config.Cluster.Enabled {
+		queue, err := declareNodeQueue(config.Queues.ManagerCommand)
+		failOnError(err, "Failed to declare a queue")
+
+		msgs, err := channel.Consume(
+			queue,
+			"",
+			false,
+			false,
+			false,
+			false,
+			nil,
+		)
+		failOnError(err, "Failed to register a consumer")
+
+		go server.commandLoop(msgs, false)
+	}
+
+	<-forever
+}
+
+func (server *Server) commandLoop(msgs <-chan amqp.Delivery, shared bool) {
+	for d := range msgs {
+		managerId := commandManagerId(d.Body)
+
+		if shared && routeToOwner(config.Queues.ManagerCommand, managerId, d.Body) {
 			d.Ack(false)
+			continue
 		}
-	}()
 
-	<-forever
+		hash := fnv.New32a()
+		hash.Write([]byte(managerId))
+
+		server.workers[hash.Sum32()%uint32(len(server.workers))] <- d
+	}
+}
+
+func (server *Server) worker(deliveries chan amqp.Delivery) {
+	for d := range deliveries {
+		server.handleCommand(d.Body)
+		d.Ack(false)
+	}
 }
 
 func (server *Server) start() {
 	for {
 		select {
 		case manager := <-server.online:
-			if _, ok := server.managers[manager.Id]; ok {
+			if existing, ok := server.manager(manager.Id); ok {
+				if manager.presence != "" && manager.presence != existing.presence {
+					err := existing.setPresence(manager.presence)
+
+					if err != nil {
+						logger.WithFields(logrus.Fields{
+							"manager":  manager.Id,
+							"presence": manager.presence,
+							"err":      err,
+						}).Error("Manager can`t change presence:")
+					}
+
+					continue
+				}
 
 				logger.WithFields(logrus.Fields{
 					"manager": manager.Id,
 				}).Warn("Manager already online:")
 
 			} else {
-				login, password, err := getCredentials(manager.Id)
+				if !validPresence(manager.presence) {
+					presence, err := getPresence(manager.Id)
 
-				if err != nil {
+					if err != nil || !validPresence(presence) {
+						presence = PresenceAvailable
+					}
+
+					manager.presence = presence
+				}
+
+				if !server.claim(manager.Id) {
 					logger.WithFields(logrus.Fields{
 						"manager": manager.Id,
-						"err":     err,
-					}).Error("Manager can`t get credentials from MySQL:")
+					}).Warn("Manager is leased by another instance:")
 
-					return
+					continue
 				}
 
-				response, err := getApiKey(login, password)
+				manager.publishStatus(StatusConnecting, manager.statusReason())
+
+				err := manager.login()
 
 				if err != nil {
 					logger.WithFields(logrus.Fields{
@@ -206,70 +332,274 @@ func (server *Server) start() {
 						"err":     err,
 					}).Error("Manager can`t register:")
 
-					return
-				}
+					manager.publishStatus(StatusAuthFailed, err.Error())
+					server.release(manager.Id)
 
-				manager.SuccessLoginResponse = response
+					continue
+				}
 
-				response, err = refreshApiKey(manager)
+				err = manager.dialSocket()
 
 				if err != nil {
 					logger.WithFields(logrus.Fields{
 						"manager": manager.Id,
 						"err":     err,
-					}).Error("Manager can`t refresh token:")
+					}).Error("Can`t connect to chat socket:")
 
-					return
+					manager.publishStatus(StatusOffline, err.Error())
+					server.release(manager.Id)
+
+					continue
 				}
 
-				manager.SuccessLoginResponse = response
+				manager.quit = make(chan struct{})
+				manager.outbox = make(chan Outgoing, config.RateLimit.QueueSize)
 
-				server.managers[manager.Id] = manager
+				manager.loadChats()
+				manager.loadAutoAccept()
+
+				server.add(manager)
+
+				if recordingEnabled(manager.Id) {
+					err = manager.startRecording()
+
+					if err != nil {
+						logger.WithFields(logrus.Fields{
+							"manager": manager.Id,
+							"err":     err,
+						}).Error("Manager can`t start recording:")
+					}
+				}
 
-				manager.quit = make(chan struct{})
-				manager.connectToSocket()
 				manager.subscribe()
-				manager.auth()
+				manager.auth(manager.statusReason())
 
 				go manager.ticker()
 				go manager.reader(server)
+				go manager.sender()
+
+				if config.Features.CannedPhrases {
+					go manager.getCannedPhrases()
+				}
+
+				err = setPresence(manager.Id, manager.presence)
+
+				if err != nil {
+					logger.WithFields(logrus.Fields{
+						"manager": manager.Id,
+						"err":     err,
+					}).Error("Manager can`t store presence:")
+				}
+
+				manager.publishPresence()
 
 				logger.WithFields(logrus.Fields{
-					"manager": manager.Id,
+					"manager":  manager.Id,
+					"presence": manager.presence,
 				}).Info("Manager is online:")
 			}
 
 		case manager := <-server.offline:
 
-			if _, ok := server.managers[manager.Id]; ok {
+			if existing, ok := server.manager(manager.Id); ok {
 
 				logger.WithFields(logrus.Fields{
 					"manager": manager.Id,
 				}).Info("Manager quit:")
 
-				close(server.managers[manager.Id].quit)
-				server.managers[manager.Id].connection.Close()
-				delete(server.managers, manager.Id)
+				close(existing.quit)
+				existing.connection.Close()
+				existing.stopRecording()
+				server.remove(manager.Id)
+				server.release(manager.Id)
 
 				logger.WithFields(logrus.Fields{
 					"manager": manager.Id,
 				}).Info("Manager is offline:")
 
+				manager.publishStatus(StatusOffline, manager.statusReason())
+
 			} else {
 				logger.WithFields(logrus.Fields{
 					"manager": manager.Id,
 				}).Warn("Manager already offline:")
 			}
 
-		case command := <-server.command:
-			whatCommand := WhatCommand{}
+		case owned := <-server.owned:
+			server.dropLost(owned)
+		}
+	}
+}
 
-			logger.WithFields(logrus.Fields{
-				"manager": whatCommand.ManagerId,
-				"command": whatCommand.Params.Name,
-			}).Info("Server start work with command:")
+func (server *Server) handleCommand(command []byte) {
+	whatCommand := WhatCommand{}
+
+	err := json.Unmarshal(command, &whatCommand)
+
+	logger.WithFields(logrus.Fields{
+		"manager": whatCommand.ManagerId,
+		"command": whatCommand.Params.Name,
+	}).Info("Server start work with command:")
+
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"manager": whatCommand.ManagerId,
+			"command": whatCommand.Params.Name,
+			"err":     err,
+		}).Error("Server can`t decode command:")
+	}
+
+	if manager, ok := server.manager(whatCommand.ManagerId); ok {
+		if isCannedPhraseCommand(whatCommand.Params.Name) {
+			err := manager.cannedPhraseCommand(command)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t handle canned phrase command:")
+
+				publishCommandError(CommandErrorParams{
+					ManagerID: whatCommand.ManagerId,
+					Command:   whatCommand.Params.Name,
+					Error:     err.Error(),
+				})
+			}
+		}
+
+		if isChatCommand(whatCommand.Params.Name) {
+			chatId, err := manager.chatCommand(command)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"chat_id": chatId,
+					"err":     err,
+				}).Error("Server can`t handle chat command:")
+
+				publishCommandError(CommandErrorParams{
+					ManagerID: whatCommand.ManagerId,
+					Command:   whatCommand.Params.Name,
+					ChatID:    chatId,
+					Error:     err.Error(),
+				})
+			} else {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"chat_id": chatId,
+				}).Debug("Server queued command:")
+			}
+		}
+
+		if whatCommand.Params.Name == "presence" {
+			err := manager.presenceCommand(command)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t handle presence command:")
+
+				publishCommandError(CommandErrorParams{
+					ManagerID: whatCommand.ManagerId,
+					Command:   whatCommand.Params.Name,
+					Error:     err.Error(),
+				})
+			}
+		}
+
+		if whatCommand.Params.Name == "log_level" {
+			err := manager.logLevelCommand(command)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t handle log level command:")
+
+				publishCommandError(CommandErrorParams{
+					ManagerID: whatCommand.ManagerId,
+					Command:   whatCommand.Params.Name,
+					Error:     err.Error(),
+				})
+			}
+		}
+
+		if whatCommand.Params.Name == "record" {
+			err := manager.recordCommand(command)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t handle record command:")
+
+				publishCommandError(CommandErrorParams{
+					ManagerID: whatCommand.ManagerId,
+					Command:   whatCommand.Params.Name,
+					Error:     err.Error(),
+				})
+			}
+		}
+
+		if whatCommand.Params.Name == "chat_list" {
+			err := manager.chatListCommand(command)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t handle chat list command:")
+
+				publishCommandError(CommandErrorParams{
+					ManagerID: whatCommand.ManagerId,
+					Command:   whatCommand.Params.Name,
+					Error:     err.Error(),
+				})
+			}
+		}
+
+		if whatCommand.Params.Name == "chat_history" {
+			err := manager.chatHistoryCommand(command)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t handle chat history command:")
+			}
+		}
 
-			err := json.Unmarshal(command, &whatCommand)
+		if whatCommand.Params.Name == "auto_accept" {
+			err := manager.autoAcceptCommand(command)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t handle auto accept command:")
+
+				publishCommandError(CommandErrorParams{
+					ManagerID: whatCommand.ManagerId,
+					Command:   whatCommand.Params.Name,
+					Error:     err.Error(),
+				})
+			}
+		}
+
+		if whatCommand.Params.Name == "accept" {
+			commandToSend := AcceptCommand{}
+
+			err := json.Unmarshal(command, &commandToSend)
 
 			if err != nil {
 				logger.WithFields(logrus.Fields{
@@ -279,202 +609,289 @@ func (server *Server) start() {
 				}).Error("Server can`t decode command:")
 			}
 
-			if _, ok := server.managers[whatCommand.ManagerId]; ok {
-				manager := server.managers[whatCommand.ManagerId]
-				if whatCommand.Params.Name == "accept" {
-					commandToSend := AcceptCommand{}
+			commandToSend.ID = manager.nextRequestId()
+			commandToSend.Method = "cometan"
+			commandToSend.Jsonrpc = "2.0"
 
-					err := json.Unmarshal(command, &commandToSend)
+			outgoing := Outgoing{
+				RequestID: commandToSend.ID,
+				Command:   whatCommand.Params.Name,
+				ChatID:    commandToSend.Params.ChatID,
+				ClientID:  commandToSend.Params.ClientID,
+				Request:   commandToSend,
+			}
 
-					if err != nil {
-						logger.WithFields(logrus.Fields{
-							"manager": whatCommand.ManagerId,
-							"command": whatCommand.Params.Name,
-							"err":     err,
-						}).Error("Server can`t decode command:")
-					}
+			err = manager.enqueue(outgoing)
+
+			if err != nil {
+				manager.reject(outgoing, err)
+			}
+		}
+
+		if whatCommand.Params.Name == "agent_message" {
+			commandToSend := AgentMessageCommand{}
+			err := json.Unmarshal(command, &commandToSend)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t decode command:")
+			}
+
+			err = manager.checkChat(commandToSend.Params.ChatID)
+
+			if err != nil {
+				manager.reject(Outgoing{
+					Command:   whatCommand.Params.Name,
+					ChatID:    commandToSend.Params.ChatID,
+					ClientID:  commandToSend.Params.ClientID,
+					PrivateID: commandToSend.Params.PrivateID,
+				}, err)
+
+				return
+			}
+
+			if manager.duplicate(whatCommand.Params.Name, commandToSend.Params.ChatID, commandToSend.Params.PrivateID) {
+				return
+			}
 
-					manager.requests = manager.requests + 1
-					commandToSend.ID = manager.requests
-					commandToSend.Method = "cometan"
-					commandToSend.Jsonrpc = "2.0"
+			commandToSend.ID = manager.nextRequestId()
+			commandToSend.Method = "cometan"
+			commandToSend.Jsonrpc = "2.0"
+
+			outgoing := Outgoing{
+				RequestID: commandToSend.ID,
+				Command:   whatCommand.Params.Name,
+				ChatID:    commandToSend.Params.ChatID,
+				ClientID:  commandToSend.Params.ClientID,
+				PrivateID: commandToSend.Params.PrivateID,
+				Request:   commandToSend,
+			}
+
+			err = manager.enqueue(outgoing)
+
+			if err != nil {
+				manager.reject(outgoing, err)
+			}
+		}
 
-					manager.mu.Lock()
-					manager.connection.WriteJSON(commandToSend)
-					manager.mu.Unlock()
+		if whatCommand.Params.Name == "agent_image" {
+			commandToSend := AgentImageCommand{}
+			err := json.Unmarshal(command, &commandToSend)
 
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t decode command:")
+			}
+
+			index := strings.Index(commandToSend.Params.Image.Src, ",")
+			data, err := base64.StdEncoding.DecodeString(commandToSend.Params.Image.Src[index+1:])
+
+			if err != nil {
+				err = fmt.Errorf("can`t decode base64 file: %s", err)
+			}
+
+			var attachment *Attachment
+
+			if err == nil {
+				attachment, err = attachmentPolicy.validate(commandToSend.Params.Image.Name, commandToSend.Params.Image.Type, data)
+			}
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"file":    commandToSend.Params.Image.Name,
+					"type":    commandToSend.Params.Image.Type,
+					"err":     err,
+				}).Warn("Server reject attachment:")
+
+				err = publishCommandError(CommandErrorParams{
+					ManagerID: whatCommand.ManagerId,
+					Command:   whatCommand.Params.Name,
+					ChatID:    commandToSend.Params.ChatID,
+					ClientID:  commandToSend.Params.ClientID,
+					PrivateID: commandToSend.Params.PrivateID,
+					Error:     err.Error(),
+				})
+
+				if err != nil {
 					logger.WithFields(logrus.Fields{
 						"manager": whatCommand.ManagerId,
 						"command": whatCommand.Params.Name,
-					}).Info("Server send command to socket:")
+						"error":   err,
+					}).Error("Failed to publish:")
 				}
 
-				if whatCommand.Params.Name == "agent_message" {
-					commandToSend := AgentMessageCommand{}
-					err := json.Unmarshal(command, &commandToSend)
+				return
+			}
 
-					if err != nil {
-						logger.WithFields(logrus.Fields{
-							"manager": whatCommand.ManagerId,
-							"command": whatCommand.Params.Name,
-							"err":     err,
-						}).Error("Server can`t decode command:")
-					}
+			err = manager.checkChat(commandToSend.Params.ChatID)
+
+			if err != nil {
+				manager.reject(Outgoing{
+					Command:   whatCommand.Params.Name,
+					ChatID:    commandToSend.Params.ChatID,
+					ClientID:  commandToSend.Params.ClientID,
+					PrivateID: commandToSend.Params.PrivateID,
+				}, err)
+
+				return
+			}
+
+			if manager.duplicate(whatCommand.Params.Name, commandToSend.Params.ChatID, commandToSend.Params.PrivateID) {
+				return
+			}
 
-					manager.requests = manager.requests + 1
-					commandToSend.ID = manager.requests
-					commandToSend.Method = "cometan"
-					commandToSend.Jsonrpc = "2.0"
+			failed := Outgoing{
+				Command:   whatCommand.Params.Name,
+				ChatID:    commandToSend.Params.ChatID,
+				ClientID:  commandToSend.Params.ClientID,
+				PrivateID: commandToSend.Params.PrivateID,
+			}
+
+			extension := strings.TrimLeft(filepath.Ext(attachment.Name), ".")
+
+			response, err := refreshApiKey(manager)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": manager.Id,
+					"err":     err,
+				}).Error("Manager can`t refresh token:")
 
-					manager.mu.Lock()
-					manager.connection.WriteJSON(commandToSend)
-					manager.mu.Unlock()
+				manager.reject(failed, fmt.Errorf("can`t refresh token: %s", err))
+
+				return
+			}
 
+			manager.SuccessLoginResponse = response
+
+			err = manager.saveSession()
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": manager.Id,
+					"err":     err,
+				}).Error("Manager can`t save session:")
+			}
+
+			uploadImageEndpoint, err := getUploadImageEndpoint(manager, extension)
+
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"manager": whatCommand.ManagerId,
+					"command": whatCommand.Params.Name,
+					"err":     err,
+				}).Error("Server can`t get uploadImageEndpoint:")
+
+				manager.reject(failed, fmt.Errorf("can`t get upload endpoint: %s", err))
+
+				return
+			}
+
+			if uploadImageEndpoint != nil {
+				logger.WithFields(logrus.Fields{
+					"data": uploadImageEndpoint,
+				}).Info("Server get uploadImageEndpoint:")
+
+				location, err := uploadImageToEndpoint(attachment, uploadImageEndpoint)
+
+				if err != nil {
 					logger.WithFields(logrus.Fields{
 						"manager": whatCommand.ManagerId,
 						"command": whatCommand.Params.Name,
-					}).Info("Server send command to socket:")
+						"err":     err,
+					}).Error("Can`t get file location:")
+
+					manager.reject(failed, fmt.Errorf("can`t upload file: %s", err))
+
+					return
 				}
 
-				if whatCommand.Params.Name == "agent_image" {
-					commandToSend := AgentImageCommand{}
-					err := json.Unmarshal(command, &commandToSend)
+				logger.WithFields(logrus.Fields{
+					"location": location,
+				}).Info("Upload file to S3:")
 
-					extension := strings.TrimLeft(filepath.Ext(commandToSend.Params.Image.Name), ".")
+				requestId := manager.nextRequestId()
 
-					response, err := refreshApiKey(manager)
+				var fileType = "document"
 
-					if err != nil {
-						logger.WithFields(logrus.Fields{
-							"manager": manager.Id,
-							"err":     err,
-						}).Error("Manager can`t refresh token:")
+				if attachment.Photo {
+					fileType = "photo"
+				}
 
-						return
-					}
+				agentImageRequestParamsMedia := AgentImageRequestParamsMedia{
+					MimeType: attachment.Type,
+					Type:     fileType,
+					File:     location,
+					FileName: attachment.Name,
+					FileURL:  location,
+					FileSize: len(attachment.Data),
+					Width:    attachment.Width,
+					Height:   attachment.Height,
+				}
 
-					manager.SuccessLoginResponse = response
+				if attachment.Photo {
+					agentImageRequestParamsMedia.Thumb = location
+				} else {
+					agentImageRequestParamsMedia.Thumb = nil
+				}
 
-					uploadImageEndpoint, err := getUploadImageEndpoint(manager, extension)
+				agentImageRequestParams := AgentImageRequestParams{
+					Name:      "agent_message",
+					Message:   commandToSend.Params.Message,
+					ChatID:    commandToSend.Params.ChatID,
+					ClientID:  commandToSend.Params.ClientID,
+					IsQuick:   commandToSend.Params.IsQuick,
+					PrivateID: commandToSend.Params.PrivateID,
+					Media:     agentImageRequestParamsMedia,
+				}
 
-					if err != nil {
-						logger.WithFields(logrus.Fields{
-							"manager": whatCommand.ManagerId,
-							"command": whatCommand.Params.Name,
-							"err":     err,
-						}).Error("Server can`t get uploadImageEndpoint:")
-					}
+				agentImageRequest := AgentImageRequest{
+					ID:      requestId,
+					Params:  agentImageRequestParams,
+					Method:  "cometan",
+					Jsonrpc: "2.0",
+				}
 
-					if uploadImageEndpoint != nil {
-						logger.WithFields(logrus.Fields{
-							"data": uploadImageEndpoint,
-						}).Info("Server get uploadImageEndpoint:")
-
-						index := strings.Index(commandToSend.Params.Image.Src, ",")
-						data, err := base64.StdEncoding.DecodeString(commandToSend.Params.Image.Src[index+1:])
-
-						if err != nil {
-							logger.WithFields(logrus.Fields{
-								"manager": whatCommand.ManagerId,
-								"command": whatCommand.Params.Name,
-								"err":     err,
-							}).Error("Can`t decode base64 file:")
-						}
-
-						location, err := uploadImageToEndpoint(commandToSend, uploadImageEndpoint, data)
-
-						if err != nil {
-							logger.WithFields(logrus.Fields{
-								"manager": whatCommand.ManagerId,
-								"command": whatCommand.Params.Name,
-								"err":     err,
-							}).Error("Can`t get file location:")
-						}
+				message, err := json.Marshal(agentImageRequest)
 
-						logger.WithFields(logrus.Fields{
-							"location": location,
-						}).Info("Upload file to S3:")
-
-						manager.requests = manager.requests + 1
-
-						var fileType = "document"
-
-						if commandToSend.Params.Image.Type == "image/jpeg" || commandToSend.Params.Image.Type == "image/png" {
-							fileType = "photo"
-						}
-
-						agentImageRequestParamsMedia := AgentImageRequestParamsMedia{
-							MimeType: commandToSend.Params.Image.Type,
-							Type:     fileType,
-							File:     location,
-							FileName: commandToSend.Params.Image.Name,
-							FileURL:  location,
-							FileSize: len(data),
-						}
-
-						if commandToSend.Params.Image.Type == "image/jpeg" || commandToSend.Params.Image.Type == "image/png" {
-							img, _, err := image.Decode(bytes.NewReader(data))
-
-							if err != nil {
-								logger.WithFields(logrus.Fields{
-									"manager": whatCommand.ManagerId,
-									"command": whatCommand.Params.Name,
-									"err":     err,
-								}).Error("Can`t decode image:")
-							}
-
-							agentImageRequestParamsMedia.Width = img.Bounds().Dx()
-							agentImageRequestParamsMedia.Height = img.Bounds().Dy()
-							agentImageRequestParamsMedia.Thumb = location
-						} else {
-							agentImageRequestParamsMedia.Thumb = nil
-						}
-
-						agentImageRequestParams := AgentImageRequestParams{
-							Name:      "agent_message",
-							Message:   commandToSend.Params.Message,
-							ChatID:    commandToSend.Params.ChatID,
-							ClientID:  commandToSend.Params.ClientID,
-							IsQuick:   commandToSend.Params.IsQuick,
-							PrivateID: commandToSend.Params.PrivateID,
-							Media:     agentImageRequestParamsMedia,
-						}
-
-						agentImageRequest := AgentImageRequest{
-							ID:      manager.requests,
-							Params:  agentImageRequestParams,
-							Method:  "cometan",
-							Jsonrpc: "2.0",
-						}
-
-						message, err := json.Marshal(agentImageRequest)
-
-						err = publishToErp(message)
-
-						if err != nil {
-							logger.WithFields(logrus.Fields{
-								"error":   err,
-								"message": string(message),
-							}).Error("Failed to publish:")
-						}
-
-						manager.mu.Lock()
-						manager.connection.WriteJSON(agentImageRequest)
-						manager.mu.Unlock()
+				err = publishToErp(newEnvelope("echo."+agentImageRequest.Params.Name, manager.Id, commandToSend.Params.PrivateID, time.Now(), message))
 
-						logger.WithFields(logrus.Fields{
-							"manager": whatCommand.ManagerId,
-							"command": whatCommand.Params.Name,
-						}).Info("Server send image message to socket:")
-					}
+				if err != nil {
+					logger.WithFields(logrus.Fields{
+						"error":   err,
+						"message": string(message),
+					}).Error("Failed to publish:")
 				}
 
-			} else {
-				logger.WithFields(logrus.Fields{
-					"manager": whatCommand.ManagerId,
-					"command": whatCommand.Params.Name,
-				}).Warn("Server receive command from offline manager:")
+				outgoing := Outgoing{
+					RequestID: agentImageRequest.ID,
+					Command:   whatCommand.Params.Name,
+					ChatID:    commandToSend.Params.ChatID,
+					ClientID:  commandToSend.Params.ClientID,
+					PrivateID: commandToSend.Params.PrivateID,
+					Request:   agentImageRequest,
+				}
+
+				err = manager.enqueue(outgoing)
+
+				if err != nil {
+					manager.reject(outgoing, err)
+				}
 			}
 		}
+
+	} else {
+		logger.WithFields(logrus.Fields{
+			"manager": whatCommand.ManagerId,
+			"command": whatCommand.Params.Name,
+		}).Warn("Server receive command from offline manager:")
 	}
 }
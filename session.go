@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+type Session struct {
+	AccessToken string    `json:"access_token"`
+	Chatserver  string    `json:"chatserver"`
+	InstanceID  string    `json:"instance_id"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func sessionKeyring() (*SecretKeyring, error) {
+	if !config.Session.Persist {
+		return nil, nil
+	}
+
+	keyring, err := parseKeyring(config.Credentials.Keys)
+
+	if err != nil || keyring.primary() == nil {
+		return nil, err
+	}
+
+	return keyring, nil
+}
+
+func loadSession(managerId string) (*Session, error) {
+	keyring, err := sessionKeyring()
+
+	if err != nil || keyring == nil {
+		return nil, err
+	}
+
+	data, err := getSession(managerId)
+
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	if !isEncrypted(*data) {
+		return nil, errors.New("session is stored in plaintext")
+	}
+
+	plain, err := keyring.decrypt(*data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{}
+
+	err = json.Unmarshal([]byte(plain), session)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Since(session.UpdatedAt) > config.Session.MaxAge {
+		return nil, nil
+	}
+
+	return session, nil
+}
+
+func (manager *Manager) saveSession() error {
+	keyring, err := sessionKeyring()
+
+	if err != nil || keyring == nil || manager.SuccessLoginResponse == nil {
+		return err
+	}
+
+	session := Session{
+		AccessToken: manager.SuccessLoginResponse.AccessToken,
+		Chatserver:  manager.SuccessLoginResponse.EndpointList.Chatserver,
+		InstanceID:  manager.instanceId,
+		UpdatedAt:   time.Now(),
+	}
+
+	data, err := json.Marshal(session)
+
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := keyring.encrypt(string(data))
+
+	if err != nil {
+		return err
+	}
+
+	return setSession(manager.Id, encrypted, session.UpdatedAt)
+}
+
+func (manager *Manager) resume() (bool, error) {
+	session, err := loadSession(manager.Id)
+
+	if err != nil || session == nil {
+		return false, err
+	}
+
+	previous := manager.SuccessLoginResponse
+
+	manager.instanceId = session.InstanceID
+	manager.SuccessLoginResponse = &SuccessLoginResponse{AccessToken: session.AccessToken, Ok: true}
+	manager.SuccessLoginResponse.EndpointList.Chatserver = session.Chatserver
+
+	response, err := refreshApiKey(manager)
+
+	if err != nil {
+		manager.SuccessLoginResponse = previous
+		return false, err
+	}
+
+	if response.EndpointList.Chatserver == "" {
+		response.EndpointList.Chatserver = session.Chatserver
+	}
+
+	manager.SuccessLoginResponse = response
+
+	return true, nil
+}
+
+func (manager *Manager) login() error {
+	resumed, err := manager.resume()
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Warn("Manager can`t resume session:")
+
+		deleteSession(manager.Id)
+	}
+
+	if resumed {
+		manager.log().Info("Manager session resumed:")
+	} else {
+		login, password, err := getCredentials(manager.Id)
+
+		if err != nil {
+			return fmt.Errorf("get credentials: %s", err)
+		}
+
+		response, err := getApiKey(login, password)
+
+		if err != nil {
+			return fmt.Errorf("login: %s", err)
+		}
+
+		manager.SuccessLoginResponse = response
+
+		response, err = refreshApiKey(manager)
+
+		if err != nil {
+			return fmt.Errorf("refresh token: %s", err)
+		}
+
+		manager.SuccessLoginResponse = response
+	}
+
+	if manager.instanceId == "" {
+		manager.instanceId = newUUID()
+	}
+
+	err = manager.saveSession()
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"err": err,
+		}).Error("Manager can`t save session:")
+	}
+
+	return nil
+}
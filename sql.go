@@ -1,7 +1,12 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	_ "github.com/go-sql-driver/mysql"
+	"strings"
 	"time"
 )
 
@@ -19,7 +24,68 @@ func getCredentials(id string) (*string, *string, error) {
 		return nil, nil, err
 	}
 
-	return c.Login, c.Password, nil
+	if c.Password == nil {
+		return c.Login, c.Password, nil
+	}
+
+	if !isEncrypted(*c.Password) && config.Credentials.RequireEncrypted {
+		return nil, nil, errors.New("password is stored in plaintext")
+	}
+
+	keyring, err := parseKeyring(config.Credentials.Keys)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	password, err := keyring.decrypt(*c.Password)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c.Login, &password, nil
+}
+
+func getPasswords() (map[string]string, error) {
+	rows, err := MySQL.Query("SELECT id, password FROM chat_jivosite_manager WHERE password IS NOT NULL")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	passwords := make(map[string]string)
+
+	for rows.Next() {
+		var id, password string
+
+		err = rows.Scan(&id, &password)
+
+		if err != nil {
+			return nil, err
+		}
+
+		passwords[id] = password
+	}
+
+	return passwords, rows.Err()
+}
+
+func setPassword(id string, password string) error {
+	var err error
+
+	stmt, err := MySQL.Prepare("UPDATE chat_jivosite_manager set password=? where id=?")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(password, id)
+	stmt.Close()
+
+	return err
 }
 
 func setStatus(id string, status bool) error {
@@ -37,6 +103,18 @@ func setStatus(id string, status bool) error {
 	return nil
 }
 
+func getStatus(id string) (bool, error) {
+	var online bool
+
+	err := MySQL.QueryRow("SELECT is_online FROM chat_jivosite_manager WHERE id = ?", id).Scan(&online)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return online, err
+}
+
 func setLastOnline(managerId string) error {
 	var err error
 
@@ -63,8 +141,363 @@ func selOfflineAll() error {
 		return err
 	}
 
-	stmt.Exec(false)
+	_, err = stmt.Exec(false)
 	stmt.Close()
 
-	return nil
+	return err
+}
+
+func saveCannedPhrases(siteId int, version interface{}, phrases []CannedPhrase) error {
+	tx, err := MySQL.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM chat_jivosite_canned_phrase WHERE site_id = ?", siteId)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO chat_jivosite_canned_phrase (site_id, phrase_id, phrase, tags, version, updated_at) VALUES (?, ?, ?, ?, ?, ?)")
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	defer stmt.Close()
+
+	var updatedAt = time.Now()
+
+	for _, phrase := range phrases {
+		_, err = stmt.Exec(siteId, phrase.ID, phrase.Phrase, strings.Join(phrase.Tags, ","), fmt.Sprint(version), updatedAt)
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func getCannedPhrasesFromDb(siteId int) ([]CannedPhrase, error) {
+	rows, err := MySQL.Query("SELECT phrase_id, phrase, tags FROM chat_jivosite_canned_phrase WHERE site_id = ? ORDER BY phrase_id", siteId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	phrases := []CannedPhrase{}
+
+	for rows.Next() {
+		var phrase CannedPhrase
+		var tags string
+
+		err = rows.Scan(&phrase.ID, &phrase.Phrase, &tags)
+
+		if err != nil {
+			return nil, err
+		}
+
+		phrase.Tags = []string{}
+
+		if tags != "" {
+			phrase.Tags = strings.Split(tags, ",")
+		}
+
+		phrases = append(phrases, phrase)
+	}
+
+	return phrases, rows.Err()
+}
+
+func getPresence(id string) (string, error) {
+	var presence *string
+
+	err := MySQL.QueryRow("SELECT presence FROM chat_jivosite_manager WHERE id = ?", id).Scan(&presence)
+
+	if err != nil || presence == nil {
+		return "", err
+	}
+
+	return *presence, nil
+}
+
+func setPresence(id string, presence string) error {
+	var err error
+
+	stmt, err := MySQL.Prepare("UPDATE chat_jivosite_manager set presence=? where id=?")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(presence, id)
+	stmt.Close()
+
+	return err
+}
+
+func getSession(id string) (*string, error) {
+	var data *string
+
+	err := MySQL.QueryRow("SELECT data FROM chat_jivosite_session WHERE manager_id = ?", id).Scan(&data)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+func setSession(id string, data string, updatedAt time.Time) error {
+	_, err := MySQL.Exec("INSERT INTO chat_jivosite_session (manager_id, data, updated_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE data = VALUES(data), updated_at = VALUES(updated_at)", id, data, updatedAt)
+
+	return err
+}
+
+func deleteSession(id string) error {
+	_, err := MySQL.Exec("DELETE FROM chat_jivosite_session WHERE manager_id = ?", id)
+
+	return err
+}
+
+func claimLease(id string, owner string, ttl time.Duration) (bool, error) {
+	_, err := MySQL.Exec("INSERT INTO chat_jivosite_lease (manager_id, owner, heartbeat_at, expires_at) VALUES (?, ?, NOW(), NOW() + INTERVAL ? SECOND) "+
+		"ON DUPLICATE KEY UPDATE owner = IF(owner = VALUES(owner) OR expires_at < NOW(), VALUES(owner), owner), "+
+		"heartbeat_at = IF(owner = VALUES(owner), VALUES(heartbeat_at), heartbeat_at), "+
+		"expires_at = IF(owner = VALUES(owner), VALUES(expires_at), expires_at)", id, owner, int(ttl.Seconds()))
+
+	if err != nil {
+		return false, err
+	}
+
+	current, err := getLeaseOwner(id)
+
+	return current == owner, err
+}
+
+func getLeaseOwner(id string) (string, error) {
+	var owner string
+
+	err := MySQL.QueryRow("SELECT owner FROM chat_jivosite_lease WHERE manager_id = ? AND expires_at > NOW()", id).Scan(&owner)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return owner, err
+}
+
+func renewLeases(owner string, ttl time.Duration) error {
+	_, err := MySQL.Exec("UPDATE chat_jivosite_lease SET heartbeat_at = NOW(), expires_at = NOW() + INTERVAL ? SECOND WHERE owner = ? AND expires_at > NOW()", int(ttl.Seconds()), owner)
+
+	return err
+}
+
+func releaseLease(id string, owner string) error {
+	_, err := MySQL.Exec("DELETE FROM chat_jivosite_lease WHERE manager_id = ? AND owner = ?", id, owner)
+
+	return err
+}
+
+func getOwnedLeases(owner string) ([]string, error) {
+	return queryLeases("SELECT manager_id FROM chat_jivosite_lease WHERE owner = ? AND expires_at > NOW()", owner)
+}
+
+func getExpiredLeases() ([]string, error) {
+	return queryLeases("SELECT manager_id FROM chat_jivosite_lease WHERE expires_at <= NOW()")
+}
+
+func queryLeases(query string, args ...interface{}) ([]string, error) {
+	rows, err := MySQL.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := []string{}
+
+	for rows.Next() {
+		var id string
+
+		err = rows.Scan(&id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func getSentMessage(chatId int, privateId string, window time.Duration) (bool, *CommandResultParams, error) {
+	var data *string
+
+	err := MySQL.QueryRow("SELECT result FROM chat_jivosite_sent_message WHERE chat_id = ? AND private_id = ? AND created_at > NOW() - INTERVAL ? SECOND", chatId, privateId, int(window.Seconds())).Scan(&data)
+
+	if err == sql.ErrNoRows {
+		return false, nil, nil
+	}
+
+	if err != nil || data == nil {
+		return err == nil, nil, err
+	}
+
+	result := &CommandResultParams{}
+
+	err = json.Unmarshal([]byte(*data), result)
+
+	if err != nil {
+		return true, nil, err
+	}
+
+	return true, result, nil
+}
+
+func insertSentMessage(managerId string, command string, chatId int, privateId string) error {
+	_, err := MySQL.Exec("INSERT INTO chat_jivosite_sent_message (chat_id, private_id, manager_id, command, result, created_at) VALUES (?, ?, ?, ?, NULL, NOW()) "+
+		"ON DUPLICATE KEY UPDATE manager_id = VALUES(manager_id), command = VALUES(command), result = NULL, created_at = NOW()", chatId, privateId, managerId, command)
+
+	return err
+}
+
+func setSentMessageResult(result CommandResultParams) error {
+	data, err := json.Marshal(result)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = MySQL.Exec("UPDATE chat_jivosite_sent_message SET result = ? WHERE chat_id = ? AND private_id = ?", string(data), result.ChatID, result.PrivateID)
+
+	return err
+}
+
+func deleteSentMessage(chatId int, privateId string) error {
+	_, err := MySQL.Exec("DELETE FROM chat_jivosite_sent_message WHERE chat_id = ? AND private_id = ?", chatId, privateId)
+
+	return err
+}
+
+func deleteSentMessages(window time.Duration) error {
+	_, err := MySQL.Exec("DELETE FROM chat_jivosite_sent_message WHERE created_at < NOW() - INTERVAL ? SECOND", int(window.Seconds()))
+
+	return err
+}
+
+func getOpenChats(managerId string) ([]Chat, error) {
+	rows, err := MySQL.Query("SELECT chat_id, client_id, visitor, state, accepted_at, last_message_at, closed_at FROM chat_jivosite_chat WHERE manager_id = ? AND state = ?", managerId, ChatOpen)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	chats := []Chat{}
+
+	for rows.Next() {
+		var chat Chat
+
+		err = rows.Scan(&chat.ChatID, &chat.ClientID, &chat.Visitor, &chat.State, &chat.AcceptedAt, &chat.LastMessageAt, &chat.ClosedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		chats = append(chats, chat)
+	}
+
+	return chats, rows.Err()
+}
+
+func saveChat(managerId string, chat Chat) error {
+	_, err := MySQL.Exec("INSERT INTO chat_jivosite_chat (manager_id, chat_id, client_id, visitor, state, accepted_at, last_message_at, closed_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW()) "+
+		"ON DUPLICATE KEY UPDATE client_id = VALUES(client_id), visitor = VALUES(visitor), state = VALUES(state), accepted_at = VALUES(accepted_at), "+
+		"last_message_at = VALUES(last_message_at), closed_at = VALUES(closed_at), updated_at = VALUES(updated_at)",
+		managerId, chat.ChatID, chat.ClientID, chat.Visitor, chat.State, chat.AcceptedAt, chat.LastMessageAt, chat.ClosedAt)
+
+	return err
+}
+
+func getAutoAcceptPolicy(managerId string) (*AutoAcceptPolicy, error) {
+	var policy AutoAcceptPolicy
+	var days string
+
+	err := MySQL.QueryRow("SELECT enabled, max_chats, hours, days FROM chat_jivosite_auto_accept WHERE manager_id = ?", managerId).Scan(&policy.Enabled, &policy.MaxChats, &policy.Hours, &days)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	policy.Days = splitList(days)
+
+	return &policy, nil
+}
+
+func setAutoAcceptPolicy(managerId string, policy AutoAcceptPolicy) error {
+	_, err := MySQL.Exec("INSERT INTO chat_jivosite_auto_accept (manager_id, enabled, max_chats, hours, days, updated_at) VALUES (?, ?, ?, ?, ?, NOW()) "+
+		"ON DUPLICATE KEY UPDATE enabled = VALUES(enabled), max_chats = VALUES(max_chats), hours = VALUES(hours), days = VALUES(days), updated_at = VALUES(updated_at)",
+		managerId, policy.Enabled, policy.MaxChats, policy.Hours, strings.Join(policy.Days, ","))
+
+	return err
+}
+
+func splitList(value string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func getAutoReplyRules() ([]AutoReplyRule, error) {
+	rows, err := MySQL.Query("SELECT id, site_id, manager_id, priority, when_away, first_message, keywords, hours, days, template, cooldown FROM chat_jivosite_auto_reply WHERE enabled = 1 ORDER BY priority DESC, id")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rules := []AutoReplyRule{}
+
+	for rows.Next() {
+		var rule AutoReplyRule
+		var keywords, days string
+		var cooldown int
+
+		err = rows.Scan(&rule.ID, &rule.SiteID, &rule.ManagerID, &rule.Priority, &rule.WhenAway, &rule.FirstMessage, &keywords, &rule.Hours, &days, &rule.Template, &cooldown)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rule.Keywords = splitList(keywords)
+		rule.Days = splitList(days)
+		rule.Cooldown = time.Duration(cooldown) * time.Second
+
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	StatusConnecting   = "connecting"
+	StatusOnline       = "online"
+	StatusAuthFailed   = "auth_failed"
+	StatusDisconnected = "disconnected"
+	StatusReconnecting = "reconnecting"
+	StatusKicked       = "kicked"
+	StatusOffline      = "offline"
+)
+
+type ManagerStatusEventParams struct {
+	Name      string    `json:"name"`
+	ManagerID string    `json:"manager_id"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason"`
+	Time      time.Time `json:"time"`
+}
+
+func (manager *Manager) publishStatus(status string, reason string) {
+	manager.log().WithFields(logrus.Fields{
+		"status": status,
+		"reason": reason,
+	}).Info("Manager status changed:")
+
+	err := publishServiceEvent(ManagerStatusEventParams{
+		Name:      "manager_status",
+		ManagerID: manager.Id,
+		Status:    status,
+		Reason:    reason,
+		Time:      time.Now(),
+	})
+
+	if err != nil {
+		manager.log().WithFields(logrus.Fields{
+			"status": status,
+			"err":    err,
+		}).Error("Failed to publish:")
+	}
+}
+
+func (manager *Manager) statusReason() string {
+	if manager.reason != "" {
+		return manager.reason
+	}
+
+	return "requested"
+}
+
+func (manager *Manager) authenticated(server *Server, response RpcResponse) {
+	result := struct {
+		Ok    *bool  `json:"ok"`
+		Error string `json:"error"`
+	}{}
+
+	json.Unmarshal(response.Result, &result)
+
+	if response.Error == nil && (result.Ok == nil || *result.Ok) {
+		manager.publishStatus(StatusOnline, manager.authReason)
+
+		if manager.authReason == "reconnected" {
+			go manager.reconcile("reconnect")
+		} else {
+			go manager.reconcile("login")
+		}
+
+		return
+	}
+
+	reason := result.Error
+
+	if response.Error != nil {
+		reason = response.Error.Message
+	}
+
+	if reason == "" {
+		reason = "socket auth rejected"
+	}
+
+	manager.log().WithFields(logrus.Fields{
+		"reason": reason,
+	}).Error("Socket auth rejected:")
+
+	manager.publishStatus(StatusAuthFailed, reason)
+
+	if server != nil {
+		manager.reason = "auth failed"
+		server.offline <- manager
+	}
+}
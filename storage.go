@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type MediaStorage interface {
+	Store(key string, contentType string, data []byte) (string, error)
+}
+
+type FileMediaStorage struct {
+	Root    string
+	BaseURL string
+}
+
+type S3MediaStorage struct {
+	Bucket   string
+	BaseURL  string
+	uploader *s3manager.Uploader
+}
+
+var mediaStorage MediaStorage
+
+func newMediaStorage() (MediaStorage, error) {
+	if !config.Features.MediaMirror {
+		return nil, nil
+	}
+
+	switch config.Media.Storage {
+	case "":
+		return nil, nil
+	case "fs":
+		if config.Media.Path == "" {
+			return nil, fmt.Errorf("MEDIA_STORAGE_PATH is required for fs media storage")
+		}
+
+		return &FileMediaStorage{config.Media.Path, strings.TrimRight(config.Media.URL, "/")}, nil
+	case "s3":
+		awsConfig := &aws.Config{
+			Region:           aws.String(config.Media.S3Region),
+			S3ForcePathStyle: aws.Bool(true),
+			Credentials: credentials.NewStaticCredentials(
+				config.Media.S3Key,
+				config.Media.S3Secret,
+				"",
+			),
+		}
+
+		if config.Media.S3Endpoint != "" {
+			awsConfig.Endpoint = aws.String(config.Media.S3Endpoint)
+		}
+
+		s, err := session.NewSession(awsConfig)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &S3MediaStorage{
+			Bucket:   config.Media.S3Bucket,
+			BaseURL:  strings.TrimRight(config.Media.URL, "/"),
+			uploader: s3manager.NewUploader(s),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown media storage %q", config.Media.Storage)
+}
+
+func (storage *FileMediaStorage) Store(key string, contentType string, data []byte) (string, error) {
+	path := filepath.Join(storage.Root, filepath.FromSlash(key))
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+
+		if err != nil {
+			return "", err
+		}
+
+		err = ioutil.WriteFile(path, data, 0644)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%s/%s", storage.BaseURL, key), nil
+}
+
+func (storage *S3MediaStorage) Store(key string, contentType string, data []byte) (string, error) {
+	output, err := storage.uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(storage.Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if storage.BaseURL != "" {
+		return fmt.Sprintf("%s/%s", storage.BaseURL, key), nil
+	}
+
+	return output.Location, nil
+}